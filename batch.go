@@ -0,0 +1,211 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"context"
+)
+
+// Batch collects transactions to send together, like uHAL's dispatch
+// model. Each queued transaction returns a handle whose value is set by
+// Batch.Dispatch, which sends the transactions in as few packets as
+// possible. A Batch is not safe for concurrent use.
+//
+//	b := target.NewBatch()
+//	id := b.Read(target.Regs["ctrl_reg.id"])
+//	fifo := b.ReadBlock(target.Regs["chan.fifo"], 256)
+//	b.Write(target.Regs["ctrl_reg.ctrl"], 1)
+//	err := b.Dispatch(ctx)
+//	fmt.Println(id.Value(), fifo.Value())
+type Batch struct {
+	t   Target
+	ops []batchop
+}
+
+// A queued transaction: send queues it, resolve sets its handle from the
+// replies and returns the error of the transaction.
+type batchop struct {
+	send    func(ctx context.Context) chan Response
+	resolve func(reps []Response, err error) error
+}
+
+// Create an empty batch of transactions for t.
+func (t Target) NewBatch() *Batch {
+	return &Batch{t: t}
+}
+
+// ValHeader is the result of a transaction without a value, such as a
+// write. It is valid once the batch has been dispatched and the
+// transaction succeeded.
+type ValHeader struct {
+	valid bool
+	err   error
+}
+
+// Whether the transaction has been carried out.
+func (v *ValHeader) Valid() bool {
+	return v.valid
+}
+
+// Error of the transaction, nil if it succeeded or has not been dispatched.
+func (v *ValHeader) Err() error {
+	return v.err
+}
+
+func (v *ValHeader) set(err error) error {
+	v.err = err
+	v.valid = err == nil
+	return err
+}
+
+// ValWord is the single word result of a transaction.
+type ValWord struct {
+	ValHeader
+	value uint32
+}
+
+// The word read, or the previous value for read-modify-write transactions.
+// Zero until the transaction is valid.
+func (v *ValWord) Value() uint32 {
+	return v.value
+}
+
+// ValVector is the result of a block read.
+type ValVector struct {
+	ValHeader
+	values []uint32
+}
+
+// The words read, nil until the transaction is valid.
+func (v *ValVector) Value() []uint32 {
+	return v.values
+}
+
+func (b *Batch) add(send func(ctx context.Context) chan Response, resolve func([]Response, error) error) {
+	b.ops = append(b.ops, batchop{send, resolve})
+}
+
+// Number of transactions waiting to be dispatched.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Queue a single word read of reg.
+func (b *Batch) Read(reg Register) *ValWord {
+	v := &ValWord{}
+	b.add(func(ctx context.Context) chan Response {
+		return b.t.read(ctx, reg, 1, false)
+	}, func(reps []Response, err error) error {
+		if err == nil {
+			v.value = reps[0].Data[0]
+		}
+		return v.set(err)
+	})
+	return v
+}
+
+// Queue a read of the value of mask of reg.
+func (b *Batch) ReadMask(reg Register, mask string) *ValWord {
+	v := &ValWord{}
+	b.add(func(ctx context.Context) chan Response {
+		return b.t.read(ctx, reg, 1, false)
+	}, func(reps []Response, err error) error {
+		if err == nil {
+			v.value, err = reg.ReadMask(mask, reps[0].Data[0])
+		}
+		return v.set(err)
+	})
+	return v
+}
+
+// Queue a read of nword words from reg.
+func (b *Batch) ReadBlock(reg Register, nword uint) *ValVector {
+	v := &ValVector{}
+	b.add(func(ctx context.Context) chan Response {
+		return b.t.read(ctx, reg, nword, false)
+	}, func(reps []Response, err error) error {
+		if err == nil {
+			values := make([]uint32, 0, int(nword))
+			for _, r := range reps {
+				values = append(values, r.Data...)
+			}
+			v.values = values
+		}
+		return v.set(err)
+	})
+	return v
+}
+
+// Queue a write of data to reg.
+func (b *Batch) Write(reg Register, data ...uint32) *ValHeader {
+	v := &ValHeader{}
+	b.add(func(ctx context.Context) chan Response {
+		return b.t.write(ctx, reg, data)
+	}, func(reps []Response, err error) error {
+		return v.set(err)
+	})
+	return v
+}
+
+// Queue an RMWbits of reg, the handle gets the previous value.
+func (b *Batch) RMWbits(reg Register, andterm, orterm uint32) *ValWord {
+	return b.rmw(reg, rmwbits, []uint32{andterm, orterm})
+}
+
+// Queue an RMWsum of reg, the handle gets the previous value.
+func (b *Batch) RMWsum(reg Register, addend uint32) *ValWord {
+	return b.rmw(reg, rmwsum, []uint32{addend})
+}
+
+// Queue setting mask of reg to value, the handle gets the previous value
+// of reg.
+func (b *Batch) MaskedWrite(reg Register, mask string, value uint32) *ValWord {
+	andterm, orterm, err := maskterms(reg, mask, value)
+	if err != nil {
+		v := &ValWord{}
+		b.add(func(ctx context.Context) chan Response {
+			return rejected(err, BusWriteError)
+		}, func(reps []Response, err error) error {
+			return v.set(err)
+		})
+		return v
+	}
+	return b.RMWbits(reg, andterm, orterm)
+}
+
+func (b *Batch) rmw(reg Register, tid typeID, data []uint32) *ValWord {
+	v := &ValWord{}
+	b.add(func(ctx context.Context) chan Response {
+		return b.t.rmw(ctx, reg, tid, data)
+	}, func(reps []Response, err error) error {
+		if err == nil {
+			v.value = reps[0].Data[0]
+		}
+		return v.set(err)
+	})
+	return v
+}
+
+// Send the queued transactions and set their handles once every reply has
+// been received, or ctx is done. Returns the first error of any
+// transaction. The batch is empty afterwards and can be reused.
+func (b *Batch) Dispatch(ctx context.Context) error {
+	ops := b.ops
+	b.ops = nil
+	rcs := make([]chan Response, len(ops))
+	for i, op := range ops {
+		rcs[i] = op.send(ctx)
+	}
+	b.t.Flush()
+	err := error(nil)
+	for i, op := range ops {
+		reps, operr := b.t.collect(ctx, rcs[i])
+		operr = op.resolve(reps, operr)
+		if operr != nil && err == nil {
+			err = operr
+		}
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A struct field tagged with the name of a mask of a register.
+type bitfield struct {
+	index int
+	mask  msk
+}
+
+// Find the fields of the struct pointed to by v that are tagged with the
+// name of a mask of r, e.g.
+//
+//	type Status struct {
+//		Locked bool   `ipbus:"mmcm_locked"`
+//		Errors uint16 `ipbus:"err_cnt"`
+//	}
+//
+// Fields must be unsigned integers or bools. Untagged fields and fields
+// tagged "-" are ignored.
+func (r Register) bitfields(v interface{}) (reflect.Value, []bitfield, error) {
+	pv := reflect.ValueOf(v)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() || pv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, nil, fmt.Errorf("Need a pointer to a struct, not %T.", v)
+	}
+	sv := pv.Elem()
+	st := sv.Type()
+	fields := []bitfield{}
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		name := strings.Split(f.Tag.Get("ipbus"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if f.PkgPath != "" {
+			// Unexported fields cannot be set through reflection.
+			return reflect.Value{}, nil, fmt.Errorf("Field %s tagged with mask %s is not exported.", f.Name, name)
+		}
+		m, ok := r.msks[name]
+		if !ok {
+			return reflect.Value{}, nil, fmt.Errorf("Register %s has no mask %s for field %s.", r.Name, name, f.Name)
+		}
+		switch f.Type.Kind() {
+		case reflect.Bool, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return reflect.Value{}, nil, fmt.Errorf("Field %s of type %v can not hold mask %s.", f.Name, f.Type, name)
+		}
+		fields = append(fields, bitfield{i, m})
+	}
+	return sv, fields, nil
+}
+
+// Set the tagged fields of the struct pointed to by v to the values of the
+// masks of the register in val, the value of r read from the device.
+func (r Register) Decode(val uint32, v interface{}) error {
+	sv, fields, err := r.bitfields(v)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		fv := sv.Field(f.index)
+		x := (val & f.mask.value) >> f.mask.shift
+		if fv.Kind() == reflect.Bool {
+			fv.SetBool(x != 0)
+			continue
+		}
+		if fv.OverflowUint(uint64(x)) {
+			return fmt.Errorf("Mask %s of register %s value 0x%x overflows field of type %v.", f.mask.name, r.Name, x, fv.Type())
+		}
+		fv.SetUint(uint64(x))
+	}
+	return nil
+}
+
+// Terms of an RMWbits transaction setting the masks of r to the tagged
+// fields of the struct pointed to by v, leaving all other bits as they are.
+func (r Register) Encode(v interface{}) (uint32, uint32, error) {
+	sv, fields, err := r.bitfields(v)
+	if err != nil {
+		return uint32(0), uint32(0), err
+	}
+	andterm, orterm := uint32(0xffffffff), uint32(0)
+	for _, f := range fields {
+		fv := sv.Field(f.index)
+		x := uint64(0)
+		if fv.Kind() == reflect.Bool {
+			if fv.Bool() {
+				x = 1
+			}
+		} else {
+			x = fv.Uint()
+		}
+		bits := uint64(f.mask.value) >> f.mask.shift
+		if x&^bits != 0 {
+			return uint32(0), uint32(0), fmt.Errorf("Value 0x%x of field %s does not fit mask %s of register %s.", x, sv.Type().Field(f.index).Name, f.mask.name, r.Name)
+		}
+		andterm &^= f.mask.value
+		orterm |= uint32(x) << f.mask.shift
+	}
+	return andterm, orterm, nil
+}
+
+// Update the masks of reg to the tagged fields of the struct pointed to by
+// v with a single RMWbits transaction. The reply holds the previous value.
+func (t Target) WriteStruct(reg Register, v interface{}) (chan Response, error) {
+	andterm, orterm, err := reg.Encode(v)
+	if err != nil {
+		return make(chan Response), err
+	}
+	if !reg.Permission.Writable() {
+		return make(chan Response), &PermissionError{reg, "write"}
+	}
+	return t.RMWbits(reg, andterm, orterm), nil
+}
+
+// Immediately update the masks of reg to the tagged fields of v in one
+// transaction and return the previous value of reg.
+func (t Target) WriteStructNow(reg Register, v interface{}) (uint32, error) {
+	return t.WriteStructCtx(context.Background(), reg, v)
+}
+
+// Update the masks of reg to the tagged fields of v in one transaction and
+// return the previous value of reg, waiting for the reply until ctx is done.
+func (t Target) WriteStructCtx(ctx context.Context, reg Register, v interface{}) (uint32, error) {
+	andterm, orterm, err := reg.Encode(v)
+	if err != nil {
+		return uint32(0), err
+	}
+	if !reg.Permission.Writable() {
+		return uint32(0), &PermissionError{reg, "write"}
+	}
+	return t.RMWbitsCtx(ctx, reg, andterm, orterm)
+}
+
+// Immediately read reg in one transaction and decode its masks into the
+// tagged fields of the struct pointed to by v.
+func (t Target) ReadStructNow(reg Register, v interface{}) error {
+	return t.ReadStructCtx(context.Background(), reg, v)
+}
+
+// Read reg in one transaction and decode its masks into the tagged fields
+// of the struct pointed to by v, waiting for the reply until ctx is done.
+func (t Target) ReadStructCtx(ctx context.Context, reg Register, v interface{}) error {
+	if _, _, err := reg.bitfields(v); err != nil {
+		return err
+	}
+	data, err := t.ReadCtx(ctx, reg, 1)
+	if err != nil {
+		return err
+	}
+	return reg.Decode(data[0], v)
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command ipbusgen generates Go types with typed accessors for the registers
+// and bit fields of an IPbus address table, in XML or JSON. It is meant to
+// be run by go generate, e.g.
+//
+//	//go:generate go run github.com/go-daq/ipbus/cmd/ipbusgen -pkg fpga -type FPGA -o fpga_regs.go addr_table/top.xml
+//
+// which lets code built on the table write
+//
+//	fpga, err := NewFPGA(target)
+//	err = fpga.CtrlReg().Ctrl().SoftRst().Write(1)
+//
+// so that a register or field renamed or removed in the firmware breaks the
+// build once the code is regenerated.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/go-daq/ipbus"
+)
+
+func main() {
+	pkg := flag.String("pkg", os.Getenv("GOPACKAGE"), "package of the generated code, by default that running go generate")
+	name := flag.String("type", "Registers", "name of the type for the top node")
+	out := flag.String("o", "", "output file, standard output if empty")
+	strict := flag.Bool("strict", false, "fail on any problem in the address table, as ipbus.ValidateAddressTable finds")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: ipbusgen [flags] addresstable.xml\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *pkg == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	fn := flag.Arg(0)
+	root, err := ipbus.LoadAddressTable(fn, *strict)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipbusgen: %v\n", err)
+		os.Exit(1)
+	}
+	buf := &bytes.Buffer{}
+	if err := root.WriteGo(buf, *pkg, *name, fn); err != nil {
+		fmt.Fprintf(os.Stderr, "ipbusgen: %v\n", err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	if err := ioutil.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "ipbusgen: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -11,10 +11,11 @@ import (
 	"net"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 func NewCM(fn string) (CM, error) {
-	cm := CM{}
+	cm := CM{hubs: make(map[string]*ControlHub), hubmu: &sync.Mutex{}}
 	err := error(nil)
 	data, err := ioutil.ReadFile(fn)
 	if err != nil {
@@ -43,30 +44,95 @@ type CM struct {
 	Devices  []string
 	connlist connlist
 	fn       string
+	// ControlHub connections shared by all targets behind the same hub.
+	hubs  map[string]*ControlHub
+	hubmu *sync.Mutex
 }
 
-func (cm CM) Target(name string) (Target, error) {
+// Connect to the device called name in the connection file, set up with
+// opts as in New. An error is returned if the device does not answer, see
+// WithHandshakeTimeout.
+func (cm CM) Target(name string, opts ...Option) (Target, error) {
 	dir := filepath.Dir(cm.fn)
-	dest := ""
+	uri := ""
 	addr := ""
 	for _, conn := range cm.connlist.Conns {
 		if conn.Id == name {
-			dest = strings.Replace(conn.URI, "ipbusudp-2.0://", "", 1)
+			uri = conn.URI
 			addr = strings.Replace(conn.Address, "file://", "", 1)
 			addr = filepath.Join(dir, addr)
 		}
 	}
-	if dest == "" {
+	if uri == "" {
 		return Target{}, fmt.Errorf("Connection '%s' not found.", name)
 	}
-	raddr, err := net.ResolveUDPAddr("udp4", dest)
+	protocol, dest, err := splituri(uri)
 	if err != nil {
 		return Target{}, err
 	}
-	conn, err := net.DialUDP("udp", nil, raddr)
+	switch protocol {
+	case "ipbusudp-2.0":
+		raddr, err := net.ResolveUDPAddr("udp4", dest)
+		if err != nil {
+			return Target{}, err
+		}
+		conn, err := net.DialUDP("udp", nil, raddr)
+		if err != nil {
+			return Target{}, err
+		}
+		return NewWithTransport("dummy", addr, NewUDPTransport(conn), opts...)
+	case "ipbustcp-2.0":
+		conn, err := net.Dial("tcp", dest)
+		if err != nil {
+			return Target{}, err
+		}
+		return NewWithTransport("dummy", addr, NewTCPTransport(conn), opts...)
+	case "chtcp-2.0":
+		hubaddr, device, err := splithubaddr(dest)
+		if err != nil {
+			return Target{}, err
+		}
+		hub, err := cm.hub(hubaddr)
+		if err != nil {
+			return Target{}, err
+		}
+		tr, err := hub.Transport(device)
+		if err != nil {
+			return Target{}, err
+		}
+		return NewWithTransport("dummy", addr, tr, opts...)
+	}
+	return Target{}, fmt.Errorf("Connection '%s' has unsupported protocol '%s'.", name, protocol)
+}
+
+// Split a connection URI such as "ipbusudp-2.0://host:port" into protocol and address.
+func splituri(uri string) (string, string, error) {
+	parts := strings.SplitN(uri, "://", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("Invalid connection URI '%s'.", uri)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Get the connection to the ControlHub at addr, connecting if needed.
+func (cm CM) hub(addr string) (*ControlHub, error) {
+	if cm.hubs == nil {
+		return DialControlHub(addr)
+	}
+	cm.hubmu.Lock()
+	defer cm.hubmu.Unlock()
+	if hub, ok := cm.hubs[addr]; ok {
+		hub.mu.Lock()
+		err := hub.err
+		hub.mu.Unlock()
+		if err == nil {
+			return hub, nil
+		}
+	}
+	hub, err := DialControlHub(addr)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	t, err := New("dummy", addr, conn)
-	return t, err
+	cm.hubs[addr] = hub
+	return hub, nil
 }
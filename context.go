@@ -0,0 +1,136 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"context"
+)
+
+// Send all queued transactions and wait until their replies have been sent
+// to their response channels. If ctx is done first, queued transactions
+// that have not been answered are abandoned: their response channels get a
+// Response with ctx.Err() and are closed, and DispatchCtx returns ctx.Err().
+// Abandoned transactions may still be carried out by the device.
+func (t Target) DispatchCtx(ctx context.Context) error {
+	queued := make(chan struct{})
+	flushed := make(chan struct{})
+	t.enqueue(usrrequest{dispatch: true, ctx: ctx, queued: queued, flushed: flushed})
+	<-queued
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+	}
+	select {
+	case t.hw.cancels <- ctx:
+	case <-flushed:
+		return nil
+	}
+	return ctx.Err()
+}
+
+// Collect the replies from rc until it is closed, abandoning the
+// transaction if ctx is done first. Returns the data received and the first
+// error.
+func (t Target) collect(ctx context.Context, rc chan Response) ([]Response, error) {
+	reps := []Response{}
+	err := error(nil)
+	add := func(r Response) {
+		reps = append(reps, r)
+		if r.Err != nil && err == nil {
+			err = r.Err
+		}
+	}
+	for {
+		select {
+		case r, ok := <-rc:
+			if !ok {
+				return reps, err
+			}
+			add(r)
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+	// Keep reading replies until the hw has taken the request to abandon
+	// the transaction, so it is never blocked sending a reply.
+	for cancelled := false; !cancelled; {
+		select {
+		case t.hw.cancels <- ctx:
+			cancelled = true
+		case r, ok := <-rc:
+			if !ok {
+				return reps, err
+			}
+			add(r)
+		}
+	}
+	for r := range rc {
+		add(r)
+	}
+	return reps, err
+}
+
+// Read nword words from reg, waiting for the reply until ctx is done.
+func (t Target) ReadCtx(ctx context.Context, reg Register, nword uint) ([]uint32, error) {
+	rc := t.read(ctx, reg, nword, false)
+	t.Flush()
+	reps, err := t.collect(ctx, rc)
+	data := make([]uint32, 0, int(nword))
+	for _, r := range reps {
+		data = append(data, r.Data...)
+	}
+	return data, err
+}
+
+// Write data to reg, waiting for the reply until ctx is done.
+func (t Target) WriteCtx(ctx context.Context, reg Register, data []uint32) error {
+	rc := t.write(ctx, reg, data)
+	t.Flush()
+	_, err := t.collect(ctx, rc)
+	return err
+}
+
+// RMWbits on reg, returning the previous value of reg, waiting for the reply
+// until ctx is done.
+func (t Target) RMWbitsCtx(ctx context.Context, reg Register, andterm, orterm uint32) (uint32, error) {
+	return t.rmwctx(ctx, reg, rmwbits, []uint32{andterm, orterm})
+}
+
+// RMWsum on reg, returning the previous value of reg, waiting for the reply
+// until ctx is done.
+func (t Target) RMWsumCtx(ctx context.Context, reg Register, addend uint32) (uint32, error) {
+	return t.rmwctx(ctx, reg, rmwsum, []uint32{addend})
+}
+
+func (t Target) rmwctx(ctx context.Context, reg Register, tid typeID, data []uint32) (uint32, error) {
+	rc := t.rmw(ctx, reg, tid, data)
+	t.Flush()
+	reps, err := t.collect(ctx, rc)
+	if err != nil {
+		return uint32(0), err
+	}
+	return reps[0].Data[0], nil
+}
+
+// Set mask of reg to value, returning the previous value of reg, waiting for
+// the reply until ctx is done.
+func (t Target) MaskedWriteCtx(ctx context.Context, reg Register, mask string, value uint32) (uint32, error) {
+	andterm, orterm, err := maskterms(reg, mask, value)
+	if err != nil {
+		return uint32(0), err
+	}
+	return t.RMWbitsCtx(ctx, reg, andterm, orterm)
+}
+
+// Read the value of mask of reg, waiting for the reply until ctx is done.
+func (t Target) MaskedReadCtx(ctx context.Context, reg Register, mask string) (uint32, error) {
+	data, err := t.ReadCtx(ctx, reg, 1)
+	if err != nil {
+		return uint32(0), err
+	}
+	return reg.ReadMask(mask, data[0])
+}
@@ -0,0 +1,286 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+/*
+   A ControlHub forwards IPbus packets between many clients and many devices,
+   taking care of UDP packet loss itself. Clients talk to it over a single
+   TCP connection. Each IPbus packet sent to the hub is preceded by:
+
+       word 0: number of bytes following word 0
+       word 1: device IPv4 address
+       word 2: device port (upper 16 bits), number of payload words (lower 16 bits)
+
+   and each reply is preceded by:
+
+       word 0: number of bytes following word 0
+       word 1: device IPv4 address
+       word 2: device port (upper 16 bits), error code (lower 16 bits)
+
+   All preamble words are big endian. If the error code is not zero there is
+   no IPbus payload.
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+var hubErrors = map[uint16]string{
+	0: "success",
+	1: "no reply to control packet",
+	2: "internal timeout within ControlHub",
+	3: "no reply to status packet",
+	4: "no reply to resend request",
+	5: "malformed status packet",
+	6: "request uses incorrect protocol version",
+	7: "device uses incorrect protocol version",
+}
+
+// Error reported by a ControlHub instead of a reply from the device.
+type hubError struct {
+	code   uint16
+	target string
+}
+
+func (e *hubError) Error() string {
+	desc, ok := hubErrors[e.code]
+	if !ok {
+		desc = "unknown error"
+	}
+	return fmt.Sprintf("ControlHub error code %d for %s: %s", e.code, e.target, desc)
+}
+
+// The ControlHub got no reply from the device.
+func (e *hubError) Is(target error) bool {
+	return target == ErrTimeout && e.code >= 1 && e.code <= 4
+}
+
+// Device address in the form used in the ControlHub preamble.
+type hubtarget struct {
+	ip   uint32
+	port uint16
+}
+
+func (t hubtarget) String() string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, t.ip)
+	return fmt.Sprintf("%v:%d", ip, t.port)
+}
+
+func resolvehubtarget(addr string) (hubtarget, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return hubtarget{}, err
+	}
+	ip := raddr.IP.To4()
+	if ip == nil {
+		return hubtarget{}, fmt.Errorf("ControlHub target %s is not an IPv4 address.", addr)
+	}
+	return hubtarget{binary.BigEndian.Uint32(ip), uint16(raddr.Port)}, nil
+}
+
+// Split the address of a chtcp-2.0 URI, "host:port?target=ip:port", into
+// the hub and device addresses.
+func splithubaddr(addr string) (string, string, error) {
+	parts := strings.SplitN(addr, "?target=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("Invalid ControlHub address '%s', expected host:port?target=ip:port.", addr)
+	}
+	return parts[0], parts[1], nil
+}
+
+// ControlHub is a client connection to a uHAL ControlHub. Any number of
+// devices can be reached through the same hub connection.
+type ControlHub struct {
+	conn    net.Conn
+	mu      sync.Mutex // Guards writes to conn and targets
+	targets map[hubtarget]*hubtransport
+	err     error
+}
+
+// Connect to the ControlHub at addr, e.g. "localhost:10203".
+func DialControlHub(addr string) (*ControlHub, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	ch := &ControlHub{conn: conn, targets: make(map[hubtarget]*hubtransport)}
+	go ch.receive()
+	return ch, nil
+}
+
+// Create a Transport to the device at target ("ip:port") through the hub,
+// to pass to NewWithTransport. Only one transport per device can be open at
+// a time.
+func (ch *ControlHub) Transport(target string) (Transport, error) {
+	t, err := resolvehubtarget(target)
+	if err != nil {
+		return nil, err
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.err != nil {
+		return nil, ch.err
+	}
+	if _, ok := ch.targets[t]; ok {
+		return nil, fmt.Errorf("ControlHub at %v already has a connection to %v.", ch.conn.RemoteAddr(), t)
+	}
+	hc := &hubtransport{hub: ch, target: t, replies: make(chan hubreply, 16), done: make(chan bool)}
+	ch.targets[t] = hc
+	return hc, nil
+}
+
+// Close the connection to the hub and every device transport using it.
+func (ch *ControlHub) Close() error {
+	return ch.conn.Close()
+}
+
+func (ch *ControlHub) send(t hubtarget, data []byte) (int, error) {
+	if len(data)%4 != 0 {
+		return 0, fmt.Errorf("IPbus packet of %d bytes is not a whole number of words.", len(data))
+	}
+	chunk := make([]byte, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[0:4], uint32(8+len(data)))
+	binary.BigEndian.PutUint32(chunk[4:8], t.ip)
+	binary.BigEndian.PutUint16(chunk[8:10], t.port)
+	binary.BigEndian.PutUint16(chunk[10:12], uint16(len(data)/4))
+	copy(chunk[12:], data)
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.err != nil {
+		return 0, ch.err
+	}
+	n, err := ch.conn.Write(chunk)
+	n -= 12
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+// Read replies from the hub and pass them on to the matching device transport.
+func (ch *ControlHub) receive() {
+	err := error(nil)
+	for err == nil {
+		var chunk []byte
+		chunk, err = readframe(ch.conn, nil)
+		if err != nil {
+			break
+		}
+		if len(chunk) < 8 {
+			err = fmt.Errorf("ControlHub reply of %d bytes is too short.", len(chunk))
+			break
+		}
+		t := hubtarget{binary.BigEndian.Uint32(chunk[0:4]), binary.BigEndian.Uint16(chunk[4:6])}
+		code := binary.BigEndian.Uint16(chunk[6:8])
+		rep := hubreply{data: chunk[8:]}
+		if code != 0 {
+			rep = hubreply{err: &PacketError{&hubError{code, t.String()}}}
+		}
+		ch.mu.Lock()
+		hc, ok := ch.targets[t]
+		ch.mu.Unlock()
+		if !ok {
+			if verbose {
+				fmt.Printf("ControlHub reply from %v, which has no transport.\n", t)
+			}
+			continue
+		}
+		select {
+		case hc.replies <- rep:
+		case <-hc.done:
+		}
+	}
+	ch.mu.Lock()
+	ch.err = fmt.Errorf("ControlHub connection to %v lost: %v", ch.conn.RemoteAddr(), err)
+	for t, hc := range ch.targets {
+		close(hc.done)
+		delete(ch.targets, t)
+	}
+	ch.mu.Unlock()
+	ch.conn.Close()
+}
+
+type hubreply struct {
+	data []byte
+	err  error
+}
+
+// Address of a device behind a ControlHub.
+type hubaddr struct {
+	hub    net.Addr
+	target hubtarget
+}
+
+func (a hubaddr) Network() string {
+	return "chtcp"
+}
+
+func (a hubaddr) String() string {
+	return fmt.Sprintf("%v?target=%v", a.hub, a.target)
+}
+
+// hubtransport is the Transport for a single device behind a ControlHub.
+// If the hub reports an error instead of a reply Receive returns a
+// *PacketError and the transport can still be used.
+type hubtransport struct {
+	hub     *ControlHub
+	target  hubtarget
+	replies chan hubreply
+	done    chan bool // Closed when the transport is closed
+}
+
+func (c *hubtransport) Receive(b []byte) (int, error) {
+	rep := hubreply{}
+	select {
+	case rep = <-c.replies:
+	case <-c.done:
+		return 0, io.EOF
+	}
+	if rep.err != nil {
+		return 0, rep.err
+	}
+	if len(rep.data) > len(b) {
+		return 0, &PacketError{fmt.Errorf("%d byte packet from %v exceeds %d byte buffer", len(rep.data), c.target, len(b))}
+	}
+	return copy(b, rep.data), nil
+}
+
+func (c *hubtransport) Send(b []byte) error {
+	n, err := c.hub.send(c.target, b)
+	if err != nil {
+		return fmt.Errorf("Failed after sending %d bytes: %v", n, err)
+	}
+	return nil
+}
+
+// The hub recovers lost packets itself.
+func (c *hubtransport) Reliable() bool {
+	return true
+}
+
+func (c *hubtransport) MaxPacketSize() int {
+	return defaultpacketsize()
+}
+
+func (c *hubtransport) RemoteAddr() net.Addr {
+	return hubaddr{c.hub.conn.RemoteAddr(), c.target}
+}
+
+// Close the device transport, the connection to the hub stays open.
+func (c *hubtransport) Close() error {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	if hc, ok := c.hub.targets[c.target]; ok && hc == c {
+		delete(c.hub.targets, c.target)
+		close(c.done)
+	}
+	return nil
+}
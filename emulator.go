@@ -0,0 +1,415 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Number of replies the emulator keeps to answer resend requests.
+const emulatorbuffers = 16
+
+// Emulator is a pure Go IPbus 2.0 device listening on UDP or TCP. It keeps a sparse
+// 32-bit memory, answers read, write, non-incrementing, RMWbits and RMWsum
+// transactions and implements the status and resend packets, so it can stand
+// in for real hardware (or the uHAL DummyHardwareUdp.exe) in tests.
+//
+// Unwritten addresses read as zero. A non-incrementing write stores every
+// word at the same address, so a FIFO reads back the last value written.
+//
+// Over TCP packets are length prefixed as in uHAL and packet IDs are not
+// checked, since a stream cannot lose packets.
+type Emulator struct {
+	conn    *net.UDPConn
+	ln      net.Listener
+	streams map[net.Conn]bool
+	mu      sync.Mutex
+	mem     map[uint32]uint32
+	mtu     uint32
+	nextid  uint16
+	replies map[uint16][]byte
+	// Packet IDs of the stored replies, oldest first.
+	replyids       []uint16
+	received, sent [][]byte // Last four control packet headers in and out
+	// Number of UDP requests and replies still to be dropped.
+	droprequests, dropreplies int
+	done                      chan bool
+}
+
+// Create an emulator listening on addr (e.g. "localhost:60001") and start
+// serving requests. Use port 0 to pick a free port and Addr to find it.
+func NewEmulator(addr string) (*Emulator, error) {
+	laddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", laddr)
+	if err != nil {
+		return nil, err
+	}
+	e := newemulator()
+	e.conn = conn
+	go e.run()
+	return e, nil
+}
+
+// Create an emulator accepting TCP connections on addr and start serving
+// requests.
+func NewTCPEmulator(addr string) (*Emulator, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	e := newemulator()
+	e.ln = ln
+	go e.accept()
+	return e, nil
+}
+
+func newemulator() *Emulator {
+	return &Emulator{mem: make(map[uint32]uint32), mtu: uint32(MaxPacketSize),
+		nextid: 1, replies: make(map[uint16][]byte), streams: make(map[net.Conn]bool),
+		done: make(chan bool)}
+}
+
+// Local address the emulator is listening on.
+func (e *Emulator) Addr() net.Addr {
+	if e.ln != nil {
+		return e.ln.Addr()
+	}
+	return e.conn.LocalAddr()
+}
+
+// Stop serving requests and close the socket.
+func (e *Emulator) Close() error {
+	err := error(nil)
+	if e.ln != nil {
+		err = e.ln.Close()
+		e.mu.Lock()
+		for c := range e.streams {
+			c.Close()
+		}
+		e.mu.Unlock()
+	} else {
+		err = e.conn.Close()
+	}
+	<-e.done
+	return err
+}
+
+// Behave as a device that has just been power cycled: the next packet ID
+// expected is 1, the stored replies and packet history are forgotten and
+// TCP connections are dropped. The memory is kept.
+func (e *Emulator) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextid = 1
+	e.replies = make(map[uint16][]byte)
+	e.replyids = nil
+	e.received, e.sent = nil, nil
+	for c := range e.streams {
+		c.Close()
+	}
+}
+
+// Ignore the next n UDP packets received, as if they were lost on the way to
+// the device. Status and resend requests count too.
+func (e *Emulator) DropRequests(n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.droprequests = n
+}
+
+// Handle the next n UDP packets received but do not reply, as if the replies
+// were lost on the way back. The replies can still be resent.
+func (e *Emulator) DropReplies(n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dropreplies = n
+}
+
+// Whether to drop a packet, counting it against n.
+func (e *Emulator) drop(n *int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if *n <= 0 {
+		return false
+	}
+	*n--
+	return true
+}
+
+// Peek at the value stored at addr without going through IPbus.
+func (e *Emulator) Read(addr uint32) uint32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mem[addr]
+}
+
+// Store val at addr without going through IPbus.
+func (e *Emulator) Write(addr, val uint32) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mem[addr] = val
+}
+
+func (e *Emulator) run() {
+	defer close(e.done)
+	buf := make([]byte, MaxPacketSize)
+	for {
+		n, raddr, err := e.conn.ReadFromUDP(buf)
+		if err != nil {
+			if verbose {
+				fmt.Printf("Emulator stopped: %v\n", err)
+			}
+			return
+		}
+		if e.drop(&e.droprequests) {
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		reply := e.handle(data, false)
+		if reply == nil || e.drop(&e.dropreplies) {
+			continue
+		}
+		if _, err := e.conn.WriteToUDP(reply, raddr); err != nil && verbose {
+			fmt.Printf("Emulator failed to reply to %v: %v\n", raddr, err)
+		}
+	}
+}
+
+func (e *Emulator) accept() {
+	defer close(e.done)
+	for {
+		c, err := e.ln.Accept()
+		if err != nil {
+			if verbose {
+				fmt.Printf("Emulator stopped: %v\n", err)
+			}
+			return
+		}
+		e.mu.Lock()
+		e.streams[c] = true
+		e.mu.Unlock()
+		go e.serve(c)
+	}
+}
+
+// Serve length prefixed packets arriving on a TCP connection.
+func (e *Emulator) serve(c net.Conn) {
+	defer func() {
+		e.mu.Lock()
+		delete(e.streams, c)
+		e.mu.Unlock()
+		c.Close()
+	}()
+	buf := make([]byte, MaxPacketSize)
+	for {
+		data, err := readframe(c, buf)
+		if err != nil {
+			if verbose {
+				fmt.Printf("Emulator closing connection from %v: %v\n", c.RemoteAddr(), err)
+			}
+			return
+		}
+		reply := e.handle(data, true)
+		if reply == nil {
+			continue
+		}
+		if _, err := writeframe(c, reply); err != nil {
+			return
+		}
+	}
+}
+
+// Handle a single request packet, returning the reply or nil if there is none.
+// Packet IDs are only checked if the packet did not arrive over a stream.
+func (e *Emulator) handle(data []byte, stream bool) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	header, err := newPacketHeader(data)
+	if err != nil {
+		if verbose {
+			fmt.Printf("Emulator dropping packet: %v\n", err)
+		}
+		return nil
+	}
+	switch header.ptype {
+	case status:
+		return e.status()
+	case resend:
+		reply, ok := e.replies[header.pid]
+		if !ok && verbose {
+			fmt.Printf("Emulator has no reply to resend for ID = %d\n", header.pid)
+		}
+		return reply
+	case control:
+		if !stream && header.pid != 0 && header.pid != e.nextid {
+			if verbose {
+				fmt.Printf("Emulator dropping packet with ID = %d, expected %d\n", header.pid, e.nextid)
+			}
+			return nil
+		}
+		reply := e.control(header, data[4:])
+		e.received = lastheaders(e.received, data[:4])
+		e.sent = lastheaders(e.sent, reply[:4])
+		if !stream && header.pid != 0 {
+			e.store(header.pid, reply)
+			e.nextid++
+			if e.nextid == 0 {
+				e.nextid = 1
+			}
+		}
+		return reply
+	}
+	return nil
+}
+
+// Keep the most recent four packet headers, newest first.
+func lastheaders(headers [][]byte, header []byte) [][]byte {
+	h := make([]byte, 4)
+	copy(h, header)
+	headers = append([][]byte{h}, headers...)
+	if len(headers) > 4 {
+		headers = headers[:4]
+	}
+	return headers
+}
+
+func (e *Emulator) store(id uint16, reply []byte) {
+	e.replies[id] = reply
+	e.replyids = append(e.replyids, id)
+	if len(e.replyids) > emulatorbuffers {
+		delete(e.replies, e.replyids[0])
+		e.replyids = e.replyids[1:]
+	}
+}
+
+// Build a status reply, which is always big endian.
+func (e *Emulator) status() []byte {
+	data := make([]byte, 64)
+	order := binary.BigEndian
+	header := packetheader{uint8(protocolversion), 0, status, order}
+	header.encode(data)
+	order.PutUint32(data[4:8], e.mtu)
+	order.PutUint32(data[8:12], emulatorbuffers)
+	next := packetheader{uint8(protocolversion), e.nextid, control, order}
+	next.encode(data[12:16])
+	// Words 4 to 7 hold the traffic history, which is not recorded.
+	for i, h := range e.received {
+		copy(data[32+4*i:], h)
+	}
+	for i, h := range e.sent {
+		copy(data[48+4*i:], h)
+	}
+	return data
+}
+
+func (e *Emulator) busread(addr uint32, noninc bool) (uint32, InfoCode) {
+	return e.mem[addr], Success
+}
+
+func (e *Emulator) buswrite(addr, val uint32, noninc bool) InfoCode {
+	e.mem[addr] = val
+	return Success
+}
+
+// Execute the transactions of a control packet against memory.
+func (e *Emulator) control(header packetheader, data []byte) []byte {
+	return execute(header, data, e)
+}
+
+// The bus behind an emulated device. A transaction that gets an info code
+// other than Success is answered with that code and ends the packet.
+type bus interface {
+	busread(addr uint32, noninc bool) (uint32, InfoCode)
+	buswrite(addr, val uint32, noninc bool) InfoCode
+}
+
+// Execute the transactions of a control packet on b and build the reply.
+func execute(header packetheader, data []byte, b bus) []byte {
+	order := header.order
+	reply := make([]byte, 4, MaxPacketSize)
+	header.encode(reply)
+	word := func(i int) uint32 {
+		return order.Uint32(data[4*i:])
+	}
+	fail := func(th transactionheader, code InfoCode) []byte {
+		th.code = code
+		th.words = 0
+		out := make([]byte, 4)
+		th.encode(out, order)
+		return append(reply, out...)
+	}
+	for len(data) >= 4 {
+		th, _ := newTransactionHeader(data, order)
+		nwords := int(th.words)
+		nin := 0 // number of words following the transaction header
+		switch th.tid {
+		case read, readnoninc:
+			nin = 1
+		case write, writenoninc:
+			nin = 1 + nwords
+		case rmwbits:
+			nin = 3
+		case rmwsum:
+			nin = 2
+		default:
+			nin = -1
+		}
+		if th.version != uint8(protocolversion) || th.code != Request || nin < 0 || len(data) < 4*(nin+1) {
+			return fail(th, BadHeader)
+		}
+		addr := word(1)
+		code := Success
+		out := make([]byte, 4)
+		switch th.tid {
+		case read, readnoninc:
+			for i := 0; i < nwords && code == Success; i++ {
+				a := addr
+				if th.tid == read {
+					a += uint32(i)
+				}
+				v := uint32(0)
+				v, code = b.busread(a, th.tid == readnoninc)
+				val := make([]byte, 4)
+				order.PutUint32(val, v)
+				out = append(out, val...)
+			}
+		case write, writenoninc:
+			for i := 0; i < nwords && code == Success; i++ {
+				a := addr
+				if th.tid == write {
+					a += uint32(i)
+				}
+				code = b.buswrite(a, word(2+i), th.tid == writenoninc)
+			}
+		case rmwbits, rmwsum:
+			old := uint32(0)
+			old, code = b.busread(addr, false)
+			if code == Success {
+				if th.tid == rmwbits {
+					code = b.buswrite(addr, (old&word(2))|word(3), false)
+				} else {
+					code = b.buswrite(addr, old+word(2), false)
+				}
+			}
+			out = append(out, 0, 0, 0, 0)
+			order.PutUint32(out[4:], old)
+		}
+		if code != Success {
+			return fail(th, code)
+		}
+		th.code = Success
+		th.encode(out, order)
+		reply = append(reply, out...)
+		data = data[4*(nin+1):]
+	}
+	return reply
+}
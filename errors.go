@@ -0,0 +1,65 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTimeout is the error of a transaction that got no reply from the
+// device, e.g. because the request or the reply was lost. Use errors.Is to
+// check for it, it may be wrapped.
+var ErrTimeout = errors.New("No reply from the device.")
+
+// ErrTargetStopped is the error of a transaction that cannot be carried out
+// because the target has stopped.
+var ErrTargetStopped = errors.New("Target is stopped.")
+
+// ErrDeviceReset is the cause of the errors of transactions in flight when
+// the device was found to have been reset, e.g. power cycled. They may or
+// may not have been carried out.
+var ErrDeviceReset = errors.New("Device was reset.")
+
+// ReconnectEvent is sent to Target.Errors once the target has
+// resynchronised with the device after it was reset, stopped replying or
+// was disconnected. Transactions in flight at the time failed with an error
+// wrapping Cause, queued ones are sent from packet ID NextID on.
+type ReconnectEvent struct {
+	Cause  error
+	NextID uint16
+}
+
+func (e *ReconnectEvent) Error() string {
+	return fmt.Sprintf("Resynchronised with the device, next packet ID = %d, after: %v", e.NextID, e.Cause)
+}
+
+func (e *ReconnectEvent) Unwrap() error {
+	return e.Cause
+}
+
+func (c InfoCode) String() string {
+	if int(c) < len(transactionerrs) {
+		return transactionerrs[c]
+	}
+	return fmt.Sprintf("Unknown (0x%x)", uint8(c))
+}
+
+// TransactionError is the error of a transaction the device replied to with
+// an info code other than Success, e.g. BusReadTimeout. The device does not
+// carry out the rest of the packet.
+type TransactionError struct {
+	Code     InfoCode
+	Register string // Name of the register, empty if not known
+	Addr     uint32
+	PacketID uint16
+}
+
+func (e *TransactionError) Error() string {
+	if e.Register == "" {
+		return fmt.Sprintf("IPbus error: %v at 0x%x in packet %d.", e.Code, e.Addr, e.PacketID)
+	}
+	return fmt.Sprintf("IPbus error: %v on register %s at 0x%x in packet %d.", e.Code, e.Register, e.Addr, e.PacketID)
+}
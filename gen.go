@@ -0,0 +1,225 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Methods of the generated types that child accessors must not shadow.
+var reservedmethods = map[string]bool{
+	"Read": true, "Write": true, "ReadBlock": true, "WriteBlock": true, "Register": true,
+}
+
+// Go identifier for an address table name, e.g. "SoftRst" for "soft_rst".
+func goname(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	s := ""
+	for _, p := range parts {
+		if strings.ToUpper(p) == p {
+			p = strings.ToLower(p)
+		}
+		s += strings.ToUpper(p[:1]) + p[1:]
+	}
+	if s == "" || unicode.IsDigit(rune(s[0])) {
+		s = "N" + s
+	}
+	return s
+}
+
+// Generator of typed register accessors for an address table.
+type generator struct {
+	buf   bytes.Buffer
+	types map[*Node]string
+	used  map[string]bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// Pick a name from base not in used, by adding a number if needed.
+func unique(base string, used map[string]bool) string {
+	name := base
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	used[name] = true
+	return name
+}
+
+// Write Go source for package pkg to w, with a type called name for n and
+// one type for each register and bit field below it. Each type has a method
+// per child returning the type of the child, Read and Write methods
+// according to the permission of the register and ReadBlock and WriteBlock
+// for blocks and ports. fn is the address table file named in the
+// generated comments.
+func (n *Node) WriteGo(w io.Writer, pkg, name, fn string) error {
+	for _, c := range n.children {
+		if c.isfield() {
+			// The type of n has no register to access the bits of.
+			return fmt.Errorf("Bit field '%s' is directly below the top node, which is not a register.", c.Path())
+		}
+	}
+	g := &generator{types: make(map[*Node]string), used: make(map[string]bool)}
+	g.used[name] = true
+	g.used["New"+name] = true
+	n.Walk(func(c *Node) {
+		if c == n {
+			g.types[c] = name
+			return
+		}
+		path := strings.TrimPrefix(c.Path(), n.Path())
+		g.types[c] = unique(name+goname(path), g.used)
+	})
+	src := filepath.ToSlash(filepath.Base(fn))
+	g.printf("// Code generated by ipbusgen from %s. DO NOT EDIT.\n\n", src)
+	g.printf("package %s\n\n", pkg)
+	g.printf("import (\n\"fmt\"\n\n\"github.com/go-daq/ipbus\"\n)\n\n")
+	regs := lowername(name) + "Addrs"
+	g.printf("// %s gives typed access to the registers of address table %s.\n", name, src)
+	g.printf("type %s struct {\ntarget ipbus.Target\n}\n\n", name)
+	g.printf("// Check that the registers of target are those of %s and give access to them.\n", src)
+	g.printf("func New%s(target ipbus.Target) (%s, error) {\n", name, name)
+	g.printf("for name, addr := range %s {\n", regs)
+	g.printf("reg, ok := target.Regs[name]\n")
+	g.printf("if !ok {\nreturn %s{}, fmt.Errorf(\"Target %%s has no register '%%s'.\", target.Name, name)\n}\n", name)
+	g.printf("if reg.Addr != addr {\nreturn %s{}, fmt.Errorf(\"Register '%%s' of target %%s is at 0x%%x, expected 0x%%x.\", name, target.Name, reg.Addr, addr)\n}\n", name)
+	g.printf("}\nreturn %s{target}, nil\n}\n\n", name)
+	g.printf("// Addresses of the registers in %s.\n", src)
+	g.printf("var %s = map[string]uint32{\n", regs)
+	n.Walk(func(c *Node) {
+		if c != n && !c.isfield() {
+			g.printf("%q: 0x%08x,\n", c.Path(), c.Addr)
+		}
+	})
+	g.printf("}\n\n")
+	g.children(n)
+	n.Walk(func(c *Node) {
+		if c != n {
+			g.node(c)
+		}
+	})
+	out, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("Generated code is not valid Go: %v", err)
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// Unexported form of an identifier, e.g. "fpga" for "FPGA" or "myRegs" for
+// "MyRegs".
+func lowername(name string) string {
+	i := 0
+	for i < len(name) && unicode.IsUpper(rune(name[i])) {
+		i++
+	}
+	if i > 1 && i < len(name) {
+		i--
+	}
+	if i == 0 {
+		i = 1
+	}
+	return strings.ToLower(name[:i]) + name[i:]
+}
+
+// Write the accessors for the children of n.
+func (g *generator) children(n *Node) {
+	typ := g.types[n]
+	used := make(map[string]bool)
+	for k := range reservedmethods {
+		used[k] = true
+	}
+	for _, c := range n.children {
+		method := unique(goname(c.Name), used)
+		ctyp := g.types[c]
+		g.printf("// %s\n", describe(c, method))
+		if c.isfield() {
+			g.printf("func (r %s) %s() %s {\nreturn %s{r.target, r.reg}\n}\n\n", typ, method, ctyp, ctyp)
+			continue
+		}
+		g.printf("func (r %s) %s() %s {\nreturn %s{r.target, r.target.Regs[%q]}\n}\n\n",
+			typ, method, ctyp, ctyp, c.Path())
+	}
+}
+
+// Doc comment for the accessor method of c.
+func describe(c *Node, method string) string {
+	s := fmt.Sprintf("%s is %s", method, c.Name)
+	if c.isfield() {
+		s += fmt.Sprintf(", mask 0x%08x", c.Mask)
+	} else {
+		s += fmt.Sprintf(" at 0x%08x", c.Addr)
+	}
+	if c.Description != "" {
+		s += ": " + c.Description
+	}
+	return s + "."
+}
+
+// Write the type of c and its methods.
+func (g *generator) node(c *Node) {
+	typ := g.types[c]
+	g.printf("// %s is %s.\n", typ, c.Path())
+	g.printf("type %s struct {\ntarget ipbus.Target\nreg ipbus.Register\n}\n\n", typ)
+	if !c.isfield() {
+		g.printf("// The register.\n")
+		g.printf("func (r %s) Register() ipbus.Register {\nreturn r.reg\n}\n\n", typ)
+	}
+	var mask string
+	if c.Mask != 0 {
+		mask = c.Name
+	}
+	// Bit fields can only be accessed as their register allows.
+	readable, writable := c.Permission.Readable(), c.Permission.Writable()
+	if c.isfield() {
+		readable = readable && c.parent.Permission.Readable()
+		writable = writable && c.parent.Permission.Writable()
+	}
+	if readable {
+		g.printf("// Read the value")
+		if mask != "" {
+			g.printf(" of the masked bits")
+		}
+		g.printf(".\nfunc (r %s) Read() (uint32, error) {\n", typ)
+		if mask != "" {
+			g.printf("return r.target.MaskedReadNow(r.reg, %q)\n}\n\n", mask)
+		} else {
+			g.printf("data, err := r.target.ReadNow(r.reg, 1)\nif err != nil {\nreturn 0, err\n}\nreturn data[0], nil\n}\n\n")
+		}
+	}
+	if writable {
+		g.printf("// Write value")
+		if mask != "" {
+			g.printf(" to the masked bits")
+		}
+		g.printf(".\nfunc (r %s) Write(value uint32) error {\n", typ)
+		if mask != "" {
+			g.printf("_, err := r.target.MaskedWriteNow(r.reg, %q, value)\nreturn err\n}\n\n", mask)
+		} else {
+			g.printf("return r.target.WriteNow(r.reg, []uint32{value})\n}\n\n")
+		}
+	}
+	if !c.isfield() && (c.Size > 1 || isnoninc(c.Mode)) {
+		if readable {
+			g.printf("// Read nword words.\n")
+			g.printf("func (r %s) ReadBlock(nword uint) ([]uint32, error) {\nreturn r.target.ReadNow(r.reg, nword)\n}\n\n", typ)
+		}
+		if writable {
+			g.printf("// Write the words of data.\n")
+			g.printf("func (r %s) WriteBlock(data []uint32) error {\nreturn r.target.WriteNow(r.reg, data)\n}\n\n", typ)
+		}
+	}
+	g.children(c)
+}
@@ -1 +1,3 @@
 module github.com/go-daq/ipbus
+
+go 1.13
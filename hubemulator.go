@@ -0,0 +1,206 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ControlHubEmulator is a minimal local stand-in for a uHAL ControlHub, to
+// test ControlHub clients without the Erlang application. It accepts TCP
+// connections, forwards each IPbus packet to its UDP device and sends the
+// reply back, translating between the client's packet IDs and the IDs the
+// device expects. Lost packets are not recovered, they are reported with
+// the ControlHub error codes instead.
+type ControlHubEmulator struct {
+	ln      net.Listener
+	mu      sync.Mutex
+	devices map[hubtarget]*hubdevice
+	clients map[net.Conn]bool
+	// Time to wait for a device to reply.
+	Timeout time.Duration
+	done    chan bool
+}
+
+// Create a ControlHub stand-in listening on addr and start serving clients.
+func NewControlHubEmulator(addr string) (*ControlHubEmulator, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	h := &ControlHubEmulator{ln: ln, devices: make(map[hubtarget]*hubdevice),
+		clients: make(map[net.Conn]bool), Timeout: time.Second, done: make(chan bool)}
+	go h.accept()
+	return h, nil
+}
+
+// Local address the hub is listening on.
+func (h *ControlHubEmulator) Addr() net.Addr {
+	return h.ln.Addr()
+}
+
+// Stop accepting clients and close all client and device connections.
+func (h *ControlHubEmulator) Close() error {
+	err := h.ln.Close()
+	h.mu.Lock()
+	for c := range h.clients {
+		c.Close()
+	}
+	for _, d := range h.devices {
+		d.conn.Close()
+	}
+	h.mu.Unlock()
+	<-h.done
+	return err
+}
+
+func (h *ControlHubEmulator) accept() {
+	defer close(h.done)
+	for {
+		c, err := h.ln.Accept()
+		if err != nil {
+			return
+		}
+		h.mu.Lock()
+		h.clients[c] = true
+		h.mu.Unlock()
+		go h.serve(c)
+	}
+}
+
+func (h *ControlHubEmulator) serve(c net.Conn) {
+	defer func() {
+		h.mu.Lock()
+		delete(h.clients, c)
+		h.mu.Unlock()
+		c.Close()
+	}()
+	for {
+		chunk, err := readframe(c, nil)
+		if err != nil {
+			return
+		}
+		if len(chunk) < 8 {
+			return
+		}
+		t := hubtarget{binary.BigEndian.Uint32(chunk[0:4]), binary.BigEndian.Uint16(chunk[4:6])}
+		nwords := int(binary.BigEndian.Uint16(chunk[6:8]))
+		payload := chunk[8:]
+		code := uint16(0)
+		reply := []byte{}
+		if len(payload) != 4*nwords {
+			code = 6
+		} else {
+			reply, code = h.forward(t, payload)
+		}
+		out := make([]byte, 12+len(reply))
+		binary.BigEndian.PutUint32(out[0:4], uint32(8+len(reply)))
+		binary.BigEndian.PutUint32(out[4:8], t.ip)
+		binary.BigEndian.PutUint16(out[8:10], t.port)
+		binary.BigEndian.PutUint16(out[10:12], code)
+		copy(out[12:], reply)
+		if _, err := c.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+// Send a packet to the device and return its reply or a ControlHub error code.
+func (h *ControlHubEmulator) forward(t hubtarget, data []byte) ([]byte, uint16) {
+	d, code := h.device(t)
+	if code != 0 {
+		return nil, code
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	header, err := newPacketHeader(data)
+	if err != nil {
+		return nil, 6
+	}
+	if header.ptype != control {
+		return nil, 6
+	}
+	clientid := header.pid
+	if clientid != 0 {
+		header.pid = d.nextid
+		header.encode(data)
+	}
+	reply, err := d.transact(data, h.Timeout)
+	if err != nil {
+		return nil, 1
+	}
+	if clientid != 0 {
+		d.nextid++
+		if d.nextid == 0 {
+			d.nextid = 1
+		}
+		replyheader, err := newPacketHeader(reply)
+		if err != nil {
+			return nil, 7
+		}
+		replyheader.pid = clientid
+		replyheader.encode(reply)
+	}
+	return reply, 0
+}
+
+// Get the connection to a device, asking the device which packet ID it
+// expects the first time it is used.
+func (h *ControlHubEmulator) device(t hubtarget) (*hubdevice, uint16) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if d, ok := h.devices[t]; ok {
+		return d, 0
+	}
+	raddr, err := net.ResolveUDPAddr("udp4", t.String())
+	if err != nil {
+		return nil, 3
+	}
+	conn, err := net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		return nil, 3
+	}
+	d := &hubdevice{conn: conn}
+	reply, err := d.transact(newStatusPacket(), h.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, 3
+	}
+	if len(reply) < 64 {
+		conn.Close()
+		return nil, 5
+	}
+	st, err := parseStatus(reply)
+	if err != nil {
+		conn.Close()
+		return nil, 5
+	}
+	d.nextid = st.nextid
+	h.devices[t] = d
+	return d, 0
+}
+
+type hubdevice struct {
+	conn   *net.UDPConn
+	mu     sync.Mutex
+	nextid uint16
+}
+
+func (d *hubdevice) transact(data []byte, timeout time.Duration) ([]byte, error) {
+	if _, err := d.conn.Write(data); err != nil {
+		return nil, err
+	}
+	d.conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, MaxPacketSize)
+	n, err := d.conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("No reply from %v: %v", d.conn.RemoteAddr(), err)
+	}
+	return buf[:n], nil
+}
@@ -23,37 +23,42 @@ package ipbus
        * Parse packet and transaction headers from received byte stream
 */
 import (
+	"context"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var nhw = 0
 
-func newhw(conn net.Conn, dt time.Duration) *hw {
-	raddr := conn.RemoteAddr()
-	hw := hw{Num: nhw, conn: conn, raddr: raddr, waittime: dt,
+func newhw(tr Transport, policy RetryPolicy) *hw {
+	raddr := tr.RemoteAddr()
+	hw := hw{Num: nhw, tr: tr, raddr: raddr, policy: policy.valid(),
 		nextID: uint16(1), inflight: 0, maxflight: 4,
 		reporttime: 30 * time.Second}
+	hw.reliable = tr.Reliable()
 	nhw += 1
 	//hw.nverbose = 5
 	hw.init()
-	fmt.Printf("Created new hw: %v\n", hw)
+	fmt.Printf("Created new hw: %v\n", &hw)
 	return &hw
 }
 
 type hw struct {
-	Num     int
-	replies chan hwpacket
-	//errs       chan data.ErrPack // Channel to send errors to whomever cares.
-	conn       net.Conn // UDP connection with the device.
-	raddr      net.Addr // UDP address of the hardware device.
-	configured bool     // Flag to ensure connection is configured, etc. before
-	// attempting to send data.
+	Num      int
+	replies  chan hwpacket
+	errs     chan error // Errors for whomever cares, see Target.Errors.
+	failed   error      // Set once the hw cannot carry on, failing every packet.
+	tr       Transport  // Connection with the device.
+	raddr    net.Addr   // Address of the hardware device.
+	reliable bool       // Transport never loses packets, so there is no need for
+	// the status/resend machinery.
 	// is assumed to be lost and handled as such.
-	statuses          chan targetstatus
-	nextID, timeoutid uint16 // The packet ID expected next by the hardware.
-	mtu               uint32 // The Maxmimum transmission unit is not currently used,
+	cancels           chan context.Context // Contexts of abandoned transactions
+	nextID, timeoutid uint16               // The packet ID expected next by the hardware.
+	mtu               uint32               // The Maxmimum transmission unit is not currently used,
 	// but defines the largest packet size (in bytes) to be
 	// sent. It is the hw interface's responsibility to
 	// ensure that sent requests and their replies will not
@@ -64,22 +69,35 @@ type hw struct {
 	queuedids, flyingids        idlog
 	timedout                    *time.Ticker
 	incoming                    chan *packet
-	waittime                    time.Duration
-	nverbose                    int
+	policy                      RetryPolicy
+	policies                    chan RetryPolicy // New policies, see Target.SetRetryPolicy
+	nverbose                    int32            // Read and written atomically
 	bytessent, bytesreceived    float64
 	packssent, packsreceived    float64
 	reporttime                  time.Duration
 	returnedids                 []uint16
 	returnedindex, returnedsize int
 	stopped                     bool
-	Stop                        chan bool
+	Stop                        chan bool // Closed to stop Run, see Target.Close
+	done, recvdone              chan bool // Closed once Run and receive return
+	closeonce                   sync.Once
+	closeerr                    error // Error closing the transport
 	sentout, received, returned tracker
 	resent                      uint16
 	handlinglost                bool
+	// Resynchronising with the device after it was reset, lost or
+	// disconnected: waiting for a new connection (redialing) or for the
+	// status giving the packet ID it expects (resyncing).
+	resyncing, redialing bool
+	resynccause          error // Why the hw is resynchronising
+	nlost                int   // Packets lost since the last reply
+	attempts             int   // Attempts to resynchronise or reconnect
 }
 
 func (h *hw) init() {
-	h.statuses = make(chan targetstatus, 10)
+	h.errs = make(chan error, 16)
+	h.cancels = make(chan context.Context)
+	h.policies = make(chan RetryPolicy)
 	h.replies = make(chan hwpacket, 100)
 	h.tosend = newpacketlog()
 	h.flying = newpacketlog()
@@ -92,13 +110,15 @@ func (h *hw) init() {
 	h.returnedindex = 31
 	h.returnedids = make([]uint16, h.returnedsize)
 	h.Stop = make(chan bool)
+	h.done = make(chan bool)
+	h.recvdone = make(chan bool)
 	h.sentout = newTracker(16)
 	h.received = newTracker(16)
 	h.returned = newTracker(16)
 }
 
-func (h hw) String() string {
-	return fmt.Sprintf("hw%d: LAddr = %v, RAddr = %v, dt = %v", h.Num, h.conn.LocalAddr(), h.raddr, h.waittime)
+func (h *hw) String() string {
+	return fmt.Sprintf("hw%d: RAddr = %v, dt = %v", h.Num, h.raddr, h.policy.Timeout)
 }
 
 // Connect to hw's UDP socket.
@@ -112,59 +132,94 @@ func (h *hw) config() error {
 }
 */
 
+// Restart the timer to go off after dt.
+func (h *hw) settimer(dt time.Duration) {
+	h.timedout.Stop()
+	h.timedout = time.NewTicker(dt)
+}
+
 func (h *hw) updatetimeout() {
 	if h.inflight > 0 {
 		first, ok := h.flyingids.secondoldest()
 		if ok {
-			dt := h.waittime
+			dt := h.policy.Timeout
 			firstpack, ok := h.flying.get(first)
 			if ok {
 				dt -= time.Since(firstpack.sent)
 			} else {
 				fmt.Printf("hw.updatetimeout: Warning: no first pack...\n")
 			}
-			//fmt.Printf("update timeout = %v, wait time = %d, %v since sent at %v\n", dt, h.waittime, h.flying[first].reqresp.Sent)
-			if h.nverbose > 0 {
-				fmt.Printf("update timeout = %v, wait time = %d\n", dt, h.waittime)
+			//fmt.Printf("update timeout = %v, wait time = %d, %v since sent at %v\n", dt, h.policy.Timeout, h.flying[first].reqresp.Sent)
+			if h.isverbose() {
+				fmt.Printf("update timeout = %v, wait time = %d\n", dt, h.policy.Timeout)
 			}
 			if dt < 0 {
 				dt = 1000
 			}
-			h.timedout = time.NewTicker(dt)
+			h.settimer(dt)
 			h.timeoutid = first
 		} else {
-			if h.nverbose > 0 {
+			if h.isverbose() {
 				fmt.Printf("updatetimeout() no second oldest...\n")
 			}
 		}
 	} else {
 		h.timedout.Stop()
-		if h.nverbose > 0 {
+		if h.isverbose() {
 			fmt.Printf("updatetimeout() no packets in flight, stopping ticker\n")
 		}
 	}
 }
 
+// The timer went off: a reply or status did not come in time, or it is time
+// to try reconnecting again.
+func (h *hw) timeout() {
+	switch {
+	case h.redialing:
+		h.redial()
+	case h.resyncing:
+		h.requeststatus()
+	default:
+		fmt.Printf("hw%d: lost a packet :(\nSent ID log: %v\nqueued ID log: %v\nh.nextID = %d\n", h.Num, h.flyingids, h.queuedids, h.nextID)
+		h.handlelost()
+	}
+}
+
+// Ask the device for its status to find out what happened to the oldest
+// packet in flight. The reply is handled by recover. Once the retry policy's
+// resends are used up the packets in flight fail and the hw resynchronises.
 func (h *hw) handlelost() {
+	h.nlost++
+	if h.nlost > h.policy.MaxResends {
+		h.losesync(ErrTimeout)
+		h.requeststatus()
+		return
+	}
 	h.SetVerbose(5)
-	h.timedout.Stop()
 	h.handlinglost = true
 	fmt.Printf("Trying to handle a lost packet with id = %d = 0x%x: %v.\n", h.timeoutid, h.timeoutid, time.Now())
-	//fmt.Printf("Previously returned = %v, %d\n", h.returnedids, h.returnedindex)
-	//fmt.Printf("sent out = %v\n", h.sentout)
-	//fmt.Printf("received = %v\n", h.received)
-	//fmt.Printf("returned = %v\n", h.returned)
 	fmt.Printf("Flying requests:\n")
 	for id, req := range h.flying.getall() {
 		fmt.Printf("id = %d = 0x%x: %v\n", id, id, req)
 	}
-	// Get status
-	err := h.sendstatusrequest()
-	if err != nil {
-		panic(err)
+	if err := h.sendstatusrequest(); err != nil {
+		// Handled like a lost status reply.
+		h.report(err)
 	}
-	statusreply := <-h.statuses
+	h.settimer(h.policy.wait(h.nlost))
+}
+
+// Recover the lost packet according to the status of the device.
+func (h *hw) recover(statusreply targetstatus) {
+	h.handlinglost = false
 	fmt.Printf("Found status: %v\n", statusreply)
+	if !h.inwindow(statusreply.nextid) {
+		// The device expects a packet that was never sent, it has been
+		// reset.
+		h.losesync(ErrDeviceReset)
+		h.resynced(statusreply)
+		return
+	}
 	fmt.Printf("Received headers:\n")
 	// Check if missing packet was either received or sent
 	packetreceived := false
@@ -190,11 +245,10 @@ func (h *hw) handlelost() {
 		err := h.sendresendrequest(h.timeoutid)
 		fmt.Printf("Packet sent, need to send resend request.\n")
 		if err != nil {
-			panic(err)
+			h.report(err)
 		}
-		h.nverbose = 5
-		//h.timedout = time.NewTicker(h.waittime)
-		h.updatetimeout()
+		h.SetVerbose(5)
+		h.settimer(h.policy.wait(h.nlost))
 		fmt.Printf("Handled lost packet that had been sent.\n")
 	} else if !packetreceived {
 		fmt.Printf("Packet not received, need to resend original packet (and any following ones).\n")
@@ -204,19 +258,14 @@ func (h *hw) handlelost() {
 		// to the connection again.
 		resendid := h.timeoutid
 		flying := true
-		resentpack := false
-		h.flying.Verbose = true
 		for flying {
 			var pack *packet
 			pack, flying = h.flying.get(resendid)
 			if flying {
 				// Simply write the data again
-				n, err := h.conn.Write(pack.request)
+				err := h.tr.Send(pack.request)
 				if err != nil {
-					panic(err)
-				}
-				if n != len(pack.request) {
-					panic(fmt.Errorf("hw.handlelost: Sent %d of %d bytes resending packet", n, len(pack.request)))
+					h.report(fmt.Errorf("hw%d failed resending packet %d: %w", h.Num, resendid, err))
 				}
 				pack.sent = time.Now()
 				h.flying.add(resendid, pack)
@@ -225,50 +274,179 @@ func (h *hw) handlelost() {
 				if resendid == 0 {
 					resendid = 1
 				}
-				resentpack = true
 			}
 		}
-		h.flying.Verbose = false
-		//h.timedout = time.NewTicker(h.waittime)
-		if resentpack {
-			h.updatetimeout()
-		}
+		h.settimer(h.policy.wait(h.nlost))
 	} else {
-		panic(fmt.Errorf("Packet received but not sent, not sure what to do...\n"))
+		// The device has taken the ID but has no reply to resend.
+		h.failpacket(h.timeoutid, fmt.Errorf("hw%d: packet %d received by the device but not replied to: %w", h.Num, h.timeoutid, ErrTimeout))
 	}
+	h.sendnext()
+}
+
+// Whether the device expecting packet id next has received none, some or all
+// of the packets in flight, as expected unless it was reset.
+func (h *hw) inwindow(id uint16) bool {
+	ids := h.flyingids.sorted()
+	if len(ids) == 0 {
+		return id == h.nextID
+	}
+	first, last := ids[0], ids[len(ids)-1]
+	return iddistance(first, id) <= iddistance(first, last)+1
+}
+
+// Number of packets from ID from to ID to, IDs run from 1 to 65535.
+func iddistance(from, to uint16) int {
+	return (int(to) - int(from) + 65535) % 65535
+}
+
+// Start over with a device that has been reset, stopped replying or been
+// disconnected because of cause. The packets in flight fail, the queued ones
+// are sent once the device reports the packet ID it expects.
+func (h *hw) losesync(cause error) {
 	h.handlinglost = false
-	// Better flush the outgoing just in case...
-	fmt.Printf("Calling sendnext at end of handlelost...\n")
+	h.nlost = 0
+	h.failflying(fmt.Errorf("hw%d lost synchronisation with the device: %w", h.Num, cause))
+	h.resynccause = cause
+	h.resyncing = true
+	h.attempts = 0
+}
+
+// Ask for the status of the device to resynchronise, asking again as set by
+// the retry policy. If the device does not reply the queued packets fail, but
+// the hw keeps trying for later ones.
+func (h *hw) requeststatus() {
+	h.attempts++
+	if h.attempts > h.policy.MaxResends+1 {
+		h.failqueued(fmt.Errorf("hw%d cannot resynchronise with the device: %w", h.Num, ErrTimeout))
+		h.attempts = 1
+	}
+	if err := h.sendstatusrequest(); err != nil {
+		h.report(err)
+	}
+	h.settimer(h.policy.wait(h.attempts - 1))
+}
+
+// Carry on from the packet ID the device expects, giving the queued packets
+// new IDs.
+func (h *hw) resynced(st targetstatus) {
+	h.resyncing = false
+	h.attempts = 0
+	h.nlost = 0
+	h.timedout.Stop()
+	h.mtu = st.mtu
+	h.nextID = st.nextid
+	if h.nextID == 0 {
+		h.nextID = 1
+	}
+	h.report(&ReconnectEvent{h.resynccause, h.nextID})
+	packs := []*packet{}
+	for _, id := range h.queuedids.sorted() {
+		if pack, ok := h.tosend.get(id); ok {
+			packs = append(packs, pack)
+			h.tosend.remove(id)
+		}
+	}
+	h.queuedids = newIDLog(h.queuedids.max)
+	for _, pack := range packs {
+		pack.writeheader(h.nextid())
+		h.tosend.add(pack.id, pack)
+		h.queuedids.add(pack.id)
+	}
 	h.sendnext()
 }
 
-// Get the device's status to set MTU and next ID.
-func (h *hw) ConfigDevice() {
+// Open a new connection after the old one was lost, trying again as set by
+// the retry policy if the device cannot be reached.
+func (h *hw) redial() {
+	err := h.tr.(Redialer).Redial()
+	if err != nil {
+		h.report(fmt.Errorf("hw%d failed to reconnect: %w", h.Num, err))
+		h.attempts++
+		if h.attempts > h.policy.MaxResends {
+			h.failqueued(fmt.Errorf("hw%d cannot reconnect to the device: %w", h.Num, err))
+			h.attempts = 0
+		}
+		h.settimer(h.policy.wait(h.attempts))
+		return
+	}
+	h.redialing = false
+	h.recvdone = make(chan bool)
+	go h.receive()
+	if h.reliable {
+		h.resynced(targetstatus{mtu: h.mtu, nextid: h.nextID})
+		return
+	}
+	h.attempts = 0
+	h.requeststatus()
+}
+
+// Get the device's status to set MTU and next ID, asking again every retry
+// policy timeout. It is called before Run, reading the replies itself, and
+// gives up with an error wrapping ErrTimeout if the device has not answered
+// within deadline.
+func (h *hw) ConfigDevice(deadline time.Duration) error {
 	fmt.Printf("hw.ConfigDevice()\n")
+	if h.reliable {
+		// Packets cannot be lost, the device does not need to be asked
+		// which ID it expects.
+		return nil
+	}
 	err := h.sendstatusrequest()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	giveup := time.NewTimer(deadline)
+	defer giveup.Stop()
+	retry := time.NewTicker(h.policy.Timeout)
+	defer retry.Stop()
+	for {
+		select {
+		case rep := <-h.replies:
+			if rep.closed {
+				return fmt.Errorf("hw%d connection closed: %w", h.Num, rep.Err)
+			}
+			if rep.Err != nil || rep.header.decode(rep.Data) != nil || rep.header.pid != 0 {
+				// Left over from an earlier connection.
+				if h.isverbose() {
+					fmt.Printf("hw%d ignoring reply while configuring device.\n", h.Num)
+				}
+				continue
+			}
+			statusreply, err := parseStatus(rep.Data)
+			if err != nil {
+				return err
+			}
+			h.mtu = statusreply.mtu
+			h.nextID = statusreply.nextid
+			if h.nextID == 0 {
+				h.nextID = 1
+			}
+			fmt.Printf("Configured device: MTU = %d, next ID = %d\n", h.mtu, h.nextID)
+			fmt.Printf("%d response buffers.\n", statusreply.nresponsebuffer)
+			return nil
+		case <-retry.C:
+			if err := h.sendstatusrequest(); err != nil {
+				h.report(err)
+			}
+		case <-giveup.C:
+			return fmt.Errorf("hw%d: no status reply from %v within %v: %w", h.Num, h.raddr, deadline, ErrTimeout)
+		}
 	}
-	statusreply := <-h.statuses
-	h.mtu = statusreply.mtu
-	h.nextID = uint16(statusreply.nextid)
-	fmt.Printf("Configured device: MTU = %d, next ID = %d\n", h.mtu, h.nextID)
-	fmt.Printf("%d response buffers.\n", statusreply.nresponsebuffer)
-	h.configured = true
 }
 
 // Send the next queued packet if there are slots available
 func (h *hw) sendnext() error {
-	if h.nverbose > 0 {
+	if h.isverbose() {
 		fmt.Printf("hw.sendnext()\n")
 	}
-	if h.handlinglost {
+	if h.handlinglost || h.resyncing || h.redialing {
 		fmt.Printf("%s: hw.sendnext(): Handling lost packet, not sending...\n", time.Now())
 		return nil
 	}
 	err := error(nil)
 	tosend := h.tosend.getall()
-	if h.nverbose > 0 {
+	if h.isverbose() {
 		fmt.Printf("h.sendnext: %d in flight, %d max flight, len(tosend) = %d\n", h.inflight, h.maxflight, len(tosend))
 	}
 	for h.inflight < h.maxflight && len(tosend) > 0 {
@@ -280,15 +458,19 @@ func (h *hw) sendnext() error {
 		pack, _ := h.tosend.get(first)
 		h.tosend.remove(first)
 		err = h.sendpack(pack)
-		if err != nil {
+		if err != nil && h.reliable {
+			h.fail(err)
 			return err
+		} else if err != nil {
+			// Recovered like a packet lost on the way.
+			h.report(err)
 		}
 		pack.sent = time.Now()
 		h.flying.add(first, pack)
 		h.flyingids.add(first)
 		h.inflight += 1
-		if h.inflight == 1 {
-			h.timedout = time.NewTicker(h.waittime)
+		if h.inflight == 1 && !h.reliable {
+			h.settimer(h.policy.Timeout)
 			h.timeoutid = first
 		}
 	}
@@ -296,24 +478,28 @@ func (h *hw) sendnext() error {
 }
 
 func (h *hw) SetVerbose(n int) {
-	h.nverbose = n
+	atomic.StoreInt32(&h.nverbose, int32(n))
+}
+
+func (h *hw) isverbose() bool {
+	return atomic.LoadInt32(&h.nverbose) > 0
 }
 
 func (h *hw) sendpack(pack *packet) error {
 	//fmt.Printf("Sending packet with ID = %d\n", req.reqresp.Out.ID)
 	h.sentout.add(pack.id)
-	if h.nverbose > 0 {
+	if h.isverbose() {
 		fmt.Printf("Sending request: %v\n", pack)
 	}
-	n, err := h.conn.Write(pack.request)
-	if h.nverbose > 0 {
-		fmt.Printf("Status request sent: n, err = %d, %v\n", n, err)
+	err := h.tr.Send(pack.request)
+	if h.isverbose() {
+		fmt.Printf("Request sent: err = %v\n", err)
 	}
-	h.bytessent += float64(n)
-	h.packssent += 1.0
 	if err != nil {
-		return fmt.Errorf("Failed after sending %d bytes: %v", n, err)
+		return err
 	}
+	h.bytessent += float64(len(pack.request))
+	h.packssent += 1.0
 	return error(nil)
 }
 
@@ -321,28 +507,28 @@ func (h *hw) sendstatusrequest() error {
 	data := newStatusPacket()
 	fmt.Printf("HW%d sending status request: %x\n", h.Num, data)
 	/*
-		if h.nverbose > 0 {
+		if h.isverbose() {
 			fmt.Printf("HW%d sending status request: %x\n", h.Num, data)
 		}
 	*/
-	n, err := h.conn.Write(data)
-	h.bytessent += float64(n)
-	h.packssent += 1.0
+	err := h.tr.Send(data)
 	if err != nil {
-		return fmt.Errorf("hw%d failed after sending %d bytes of status request: %v", h.Num, n, err)
+		return fmt.Errorf("hw%d failed sending status request: %v", h.Num, err)
 	}
+	h.bytessent += float64(len(data))
+	h.packssent += 1.0
 	return error(nil)
 }
 
 func (h *hw) sendresendrequest(id uint16) error {
 	data := newResendPacket(id)
-	n, err := h.conn.Write(data)
-	h.bytessent += float64(n)
-	h.packssent += 1.0
+	err := h.tr.Send(data)
 	h.resent = id
 	if err != nil {
-		return fmt.Errorf("hw%d failed after sending %d bytes of resend request: %v", h.Num, n, err)
+		return fmt.Errorf("hw%d failed sending resend request: %v", h.Num, err)
 	}
+	h.bytessent += float64(len(data))
+	h.packssent += 1.0
 	fmt.Printf("Sent resend request 0x0%04x: %02x\n", id, data)
 	return error(nil)
 }
@@ -369,160 +555,58 @@ func (h *hw) returnreply() {
 	}
 }
 
+// Fail the packets still pending or waiting to be queued, close the
+// connection and wait for receive to return.
 func (h *hw) closeall() {
-	// This should be updated to close the channels for each transaction that would close its channel after sending.
-	/*
-		for _, req := range h.replied {
-			close(req.dest)
-		}
-		for _, req := range h.flying {
-			close(req.dest)
+	if h.failed == nil {
+		h.failed = ErrTargetStopped
+	}
+	h.fail(ErrTargetStopped)
+	for queued := true; queued; {
+		select {
+		case pack := <-h.incoming:
+			h.queue(pack)
+		default:
+			queued = false
 		}
-		for _, req := range h.tosend {
-			close(req.dest)
+	}
+	h.closeerr = h.tr.Close()
+	for receiving := true; receiving; {
+		select {
+		case <-h.replies:
+		case <-h.recvdone:
+			receiving = false
 		}
-	*/
+	}
+	h.tosend.close()
+	h.flying.close()
+	h.replied.close()
 }
 
 // NB: NEED TO HANDLE STATUS REQUESTS DIFFERENTLY
 func (h *hw) Run() {
-	/*
-		if err := h.config(); err != nil {
-			panic(err)
-		}
-	*/
-	go h.ConfigDevice()
 	running := true
-	go h.receive()
 	reportticker := time.NewTicker(h.reporttime)
 	for running {
+		// Leave packets waiting while there is no room to queue them.
+		incoming := h.incoming
+		if h.queuedids.full() {
+			incoming = nil
+		}
 		select {
 		case <-h.Stop:
-			h.conn.Close()
 			fmt.Printf("hw%d following request to stop.\n", h.Num)
 			running = false
-		case pack := <-h.incoming:
-			if h.nverbose > 0 {
-				fmt.Printf("%v: hw.Run read from h.incoming: %v\n", time.Now(), pack)
-			}
-			// Handle sending out packet
-			// Will move status and resend requests to another channel.
-			/*
-				if req.reqresp.Out.Type == oldipbus.Status {
-					if err := req.reqresp.EncodeOut(); err != nil {
-						panic(fmt.Errorf("hw%d: %v", h.Num, err))
-					}
-					req.reqresp.Bytes = req.reqresp.Bytes[:req.reqresp.RespIndex]
-					h.sendpack(req)
-					h.flying[0] = req
-				} else if req.reqresp.Out.Type == oldipbus.Resend {
-					if err := req.reqresp.EncodeOut(); err != nil {
-						panic(fmt.Errorf("hw%d: %v", h.Num, err))
-					}
-					req.reqresp.Bytes = req.reqresp.Bytes[:req.reqresp.RespIndex]
-					h.resent = req.reqresp.Out.ID
-					if h.handlinglost {
-						h.sendpack(req)
-					} else {
-						fmt.Printf("Received a resend request but not hanlding lost packet, treating it like normal.\n")
-						h.tosend[req.reqresp.Out.ID] = req
-						err := h.queuedids.add(req.reqresp.Out.ID)
-						if err != nil {
-							panic(err)
-						}
-						h.sendnext()
-					}
-				}
-			*/
-			// To send out status and resend requests implement a port of the above elsewhere
-
-			if h.nverbose > 0 {
-				fmt.Printf("Adding ID to packet. hw.nextID = %d\n", h.nextID)
-
-			}
-			pack.writeheader(h.nextid())
-			//pack.id = h.nextid()
-			//req.reqresp.Out.ID = h.nextid()
-
-			// Don't need to encode data, it's already done
-			/*
-				if err := req.reqresp.EncodeOut(); err != nil {
-					panic(fmt.Errorf("hw%d: %v", h.Num, err))
-				}
-				req.reqresp.Bytes = req.reqresp.Bytes[:req.reqresp.RespIndex]
-			*/
-
-			//h.tosend[req.reqresp.Out.ID] = req
-			//err := h.queuedids.add(req.reqresp.Out.ID)
-			h.tosend.add(pack.id, pack)
-			err := h.queuedids.add(pack.id)
-			if err != nil {
-				panic(err)
-			}
-			h.sendnext()
+		case pack := <-incoming:
+			h.queue(pack)
+		case ctx := <-h.cancels:
+			h.abandon(ctx)
+		case policy := <-h.policies:
+			h.policy = policy.valid()
 		case rep := <-h.replies:
-			// Handle reply
-			// Match with requests in flight slots
-			// If it's the oldest request send it back, otherwise queue reply
-			// If there are queued requests send one
-			// Update ticker
-			err := rep.header.decode(rep.Data)
-			if err != nil {
-				// what?
-				fmt.Printf("Error decoding packet header: %v\n", err)
-			}
-			id := rep.header.pid
-			h.received.add(id)
-			if h.nverbose > 0 {
-				fmt.Printf("%v: hw.Run received reply with ID = %d = 0x%x\n", time.Now(), id, id)
-				h.nverbose -= 1
-			}
-			if id == 0 { // id == 0 should be status packet
-				st, err := parseStatus(rep.Data)
-				if err != nil {
-					// Handle error
-				}
-				// Status packets are used either for initial configuration
-				// or for deciding what to do with a lost packet
-				h.statuses <- st
-			} else {
-				req, ok := h.flying.get(id)
-				if ok {
-					h.inflight -= 1
-					oldest, _ := h.flyingids.oldest()
-					if id == oldest {
-						h.timedout.Stop()
-						h.updatetimeout()
-					}
-					h.flying.remove(id)
-					h.sendnext()
-					// Need to parse reply packet
-					/*
-						req.reqresp.Bytes = append(req.reqresp.Bytes, rep.Data...)
-						req.reqresp.RAddr = rep.RAddr
-						req.reqresp.Received = time.Now()
-						if err := req.reqresp.Decode(); err != nil {
-							panic(err)
-						}
-					*/
-					err := req.parse(rep.Data)
-					if err != nil {
-						fmt.Printf("Error parsing reply: %v\n", err)
-					}
-					h.replied.add(id, req)
-					h.returnreply()
-				} else {
-					if id == h.resent {
-						fmt.Printf("Received a resent packet with ID = %d, but not found ID in h.flying.\n", id)
-					} else {
-						panic(fmt.Errorf("hw%d: Received packet with ID = %d, no match in %v", h.Num, id, h.inflight))
-					}
-				}
-			}
+			h.handlereply(rep)
 		case <-h.timedout.C:
-			// Handle timeout on oldest packet in flight
-			fmt.Printf("hw%d: lost a packet :(\nSent ID log: %v\nqueued ID log: %v\nh.nextID = %d\n", h.Num, h.flyingids, h.queuedids, h.nextID)
-			go h.handlelost()
+			h.timeout()
 		case <-reportticker.C:
 			dt := h.reporttime.Seconds()
 			sentrate := h.bytessent / dt / 1e6
@@ -536,6 +620,264 @@ func (h *hw) Run() {
 			h.packsreceived = 0.0
 		}
 	}
+	reportticker.Stop()
+	h.closeall()
+	close(h.done)
+}
+
+// Match a reply with its packet in flight. Replies are returned to the
+// users in the order the packets were sent.
+func (h *hw) handlereply(rep hwpacket) {
+	h.bytesreceived += float64(len(rep.Data))
+	h.packsreceived += 1.0
+	if rep.closed {
+		err := fmt.Errorf("hw%d connection closed: %w", h.Num, rep.Err)
+		if _, ok := h.tr.(Redialer); ok && h.failed == nil {
+			h.report(err)
+			h.losesync(err)
+			h.redialing = true
+			h.redial()
+			return
+		}
+		h.fail(err)
+		return
+	}
+	if rep.Err != nil {
+		h.failoldest(rep.Err)
+		return
+	}
+	err := rep.header.decode(rep.Data)
+	if err != nil {
+		h.report(fmt.Errorf("hw%d received invalid reply: %w", h.Num, err))
+		return
+	}
+	id := rep.header.pid
+	h.received.add(id)
+	if h.isverbose() {
+		fmt.Printf("%v: hw.Run received reply with ID = %d = 0x%x\n", time.Now(), id, id)
+		atomic.AddInt32(&h.nverbose, -1)
+	}
+	if id == 0 { // id == 0 should be status packet
+		st, err := parseStatus(rep.Data)
+		if err != nil {
+			h.report(err)
+			return
+		}
+		// Status packets are used for deciding what to do with a
+		// lost packet or resynchronising with the device.
+		if h.resyncing && !h.redialing {
+			h.resynced(st)
+			return
+		}
+		if h.handlinglost {
+			h.recover(st)
+			return
+		}
+		h.report(fmt.Errorf("hw%d dropped unexpected status reply.", h.Num))
+		return
+	}
+	req, ok := h.flying.get(id)
+	if !ok {
+		if id == h.resent {
+			fmt.Printf("Received a resent packet with ID = %d, but not found ID in h.flying.\n", id)
+		} else {
+			h.report(fmt.Errorf("hw%d received reply with ID = %d, which is not in flight.", h.Num, id))
+		}
+		return
+	}
+	h.inflight -= 1
+	h.nlost = 0
+	oldest, _ := h.flyingids.oldest()
+	if id == oldest && !h.reliable {
+		h.timedout.Stop()
+		h.updatetimeout()
+	}
+	h.flying.remove(id)
+	h.sendnext()
+	if err := req.parse(rep.Data); err != nil {
+		h.report(fmt.Errorf("hw%d failed parsing reply: %w", h.Num, err))
+	}
+	h.replied.add(id, req)
+	h.returnreply()
+}
+
+// Give the next ID to a packet and send it when there is room in flight.
+func (h *hw) queue(pack *packet) {
+	if len(pack.transactions) == 0 {
+		h.mark(pack)
+		return
+	}
+	if h.failed != nil {
+		pack.fail(h.failed)
+		return
+	}
+	if h.isverbose() {
+		fmt.Printf("%v: hw.Run read from h.incoming: %v\n", time.Now(), pack)
+		fmt.Printf("Adding ID to packet. hw.nextID = %d\n", h.nextID)
+	}
+	if h.queuedids.full() {
+		// Only reached from abandon, Run waits for room.
+		pack.fail(fmt.Errorf("hw%d has too many packets queued.", h.Num))
+		return
+	}
+	pack.writeheader(h.nextid())
+	h.tosend.add(pack.id, pack)
+	h.queuedids.add(pack.id)
+	h.sendnext()
+}
+
+// Reply with ctx.Err() to every transaction queued with ctx that has not
+// been answered, in the order the packets were sent so that replies to
+// split transactions stay in order.
+func (h *hw) abandon(ctx context.Context) {
+	// Packets already passed on by the target must be included.
+	for queued := true; queued && !h.queuedids.full(); {
+		select {
+		case pack := <-h.incoming:
+			h.queue(pack)
+		default:
+			queued = false
+		}
+	}
+	for _, pack := range h.pending() {
+		pack.abandon(ctx)
+	}
+}
+
+// Packets that have not been replied to yet, oldest first.
+func (h *hw) pending() []*packet {
+	packs := []*packet{}
+	for _, id := range h.flyingids.sorted() {
+		if pack, ok := h.replied.get(id); ok {
+			packs = append(packs, pack)
+		} else if pack, ok := h.flying.get(id); ok {
+			packs = append(packs, pack)
+		}
+	}
+	for _, id := range h.queuedids.sorted() {
+		if pack, ok := h.tosend.get(id); ok {
+			packs = append(packs, pack)
+		}
+	}
+	return packs
+}
+
+// Apply a dispatch marker to the packets not yet replied to.
+func (h *hw) mark(marker *packet) {
+	packs := h.pending()
+	if ctx := marker.dispatchctx; ctx != nil {
+		for _, pack := range packs {
+			for i := range pack.transactions {
+				if pack.transactions[i].ctx == nil {
+					pack.transactions[i].ctx = ctx
+				}
+			}
+		}
+	}
+	if len(packs) == 0 {
+		for _, done := range marker.done {
+			close(done)
+		}
+		return
+	}
+	last := packs[len(packs)-1]
+	last.done = append(last.done, marker.done...)
+}
+
+// Fail every transaction in the oldest packet in flight with err. This is used
+// when the transport reports that a request failed, which must be the oldest
+// one as replies arrive in order over reliable transports.
+func (h *hw) failoldest(err error) {
+	id, ok := h.flyingids.oldest()
+	if !ok {
+		h.report(fmt.Errorf("hw%d: error with no packets in flight: %w", h.Num, err))
+		return
+	}
+	h.failpacket(id, err)
+}
+
+// Fail every transaction in the packet in flight with ID id with err.
+func (h *hw) failpacket(id uint16, err error) {
+	pack, ok := h.flying.get(id)
+	if !ok {
+		h.report(fmt.Errorf("hw%d: error for packet %d which is not in flight: %w", h.Num, id, err))
+		return
+	}
+	h.inflight -= 1
+	oldest, _ := h.flyingids.oldest()
+	if id == oldest && !h.reliable {
+		h.timedout.Stop()
+		h.updatetimeout()
+	}
+	h.flying.remove(id)
+	pack.failreplies(err)
+	h.replied.add(id, pack)
+	h.returnreply()
+	h.sendnext()
+}
+
+// Fail the packets in flight with err, returning the replies already
+// received in order.
+func (h *hw) failflying(err error) {
+	for _, id := range h.flyingids.sorted() {
+		if pack, ok := h.replied.get(id); ok {
+			h.replied.remove(id)
+			pack.send()
+		} else if pack, ok := h.flying.get(id); ok {
+			h.flying.remove(id)
+			pack.failreplies(err)
+			pack.send()
+		}
+	}
+	h.flyingids = newIDLog(h.flyingids.max)
+	h.inflight = 0
+	h.timedout.Stop()
+}
+
+// Fail the packets waiting to be sent with err.
+func (h *hw) failqueued(err error) {
+	for _, id := range h.queuedids.sorted() {
+		if pack, ok := h.tosend.get(id); ok {
+			h.tosend.remove(id)
+			pack.fail(err)
+		}
+	}
+	h.queuedids = newIDLog(h.queuedids.max)
+}
+
+// Fail every pending transaction, and every one queued from now on, with
+// err. The hw cannot carry on, e.g. the connection is closed.
+func (h *hw) fail(err error) {
+	if h.failed == nil {
+		h.failed = err
+		h.report(err)
+	}
+	h.timedout.Stop()
+	h.handlinglost = false
+	h.resyncing = false
+	h.redialing = false
+	for _, pack := range h.pending() {
+		pack.fail(err)
+	}
+	for _, log := range []*packetlog{h.tosend, h.flying, h.replied} {
+		for id := range log.getall() {
+			log.remove(id)
+		}
+	}
+	h.queuedids = newIDLog(h.queuedids.max)
+	h.flyingids = newIDLog(h.flyingids.max)
+	h.inflight = 0
+}
+
+// Send err to the error stream, dropping it if nobody keeps up.
+func (h *hw) report(err error) {
+	if h.isverbose() {
+		fmt.Printf("%v\n", err)
+	}
+	select {
+	case h.errs <- err:
+	default:
+	}
 }
 
 /*
@@ -556,9 +898,9 @@ func (h *hw) nextid() uint16 {
 func (h *hw) Send(p *packet) error {
 	if h.stopped {
 		fmt.Printf("Not sending a packet because hw%d is stopped.\n", h.Num)
-		return fmt.Errorf("hw%d is stopped.", h.Num)
+		return ErrTargetStopped
 	}
-	if h.nverbose > 0 {
+	if h.isverbose() {
 		fmt.Printf("Packet going into h.incoming: %v\n", p)
 	}
 	h.incoming <- p
@@ -567,17 +909,20 @@ func (h *hw) Send(p *packet) error {
 
 // Receive incoming packets
 func (h *hw) receive() {
+	defer close(h.recvdone)
 	running := true
 	for running {
-		p := emptyPacket()
-		n, err := h.conn.Read(p.Data)
-		h.bytesreceived += float64(n)
-		h.packsreceived += 1.0
-		if err != nil {
+		p := emptyPacket(h.tr.MaxPacketSize())
+		n, err := h.tr.Receive(p.Data)
+		if _, ok := err.(*PacketError); ok {
+			// A request failed, but the transport is still usable.
+			h.replies <- hwpacket{RAddr: h.raddr, Err: err}
+		} else if err != nil {
 			running = false
 			fmt.Printf("hw%d not receiving as connection closed: %v\n", h.Num, err)
+			h.replies <- hwpacket{RAddr: h.raddr, Err: err, closed: true}
 		} else {
-			if h.nverbose > 0 {
+			if h.isverbose() {
 				fmt.Printf("%v: hw.receive() packet of %d bytes: 0x%x.\n", time.Now(), n, p.Data[:n])
 			}
 			p.Data = p.Data[:n]
@@ -32,6 +32,8 @@ type hwpacket struct {
 	Data   []byte
 	RAddr  net.Addr
 	header packetheader
+	Err    error // Set if the connection reported an error instead of a reply
+	closed bool  // Set if the connection is closed, Err says why
 }
 
 type targetstatus struct {
@@ -42,6 +44,9 @@ type targetstatus struct {
 }
 
 func parseStatus(data []byte) (targetstatus, error) {
+	if len(data) < 64 {
+		return targetstatus{}, fmt.Errorf("Status reply of %d bytes is too short.", len(data))
+	}
 	mtu := byte2uint32(data[4:8], binary.BigEndian)
 	nresponsebuffer := byte2uint32(data[8:12], binary.BigEndian)
 	nextheader := byte2uint32(data[12:16], binary.BigEndian)
@@ -66,8 +71,8 @@ func parseStatus(data []byte) (targetstatus, error) {
 	return ts, error(nil)
 }
 
-func emptyPacket() hwpacket {
-	d := make([]byte, 1500)
+func emptyPacket(size int) hwpacket {
+	d := make([]byte, size)
 	return hwpacket{Data: d}
 }
 
@@ -121,6 +126,10 @@ func (i *idlog) remove() error {
 	return error(nil)
 }
 
+func (i *idlog) full() bool {
+	return i.n == i.max
+}
+
 func (i *idlog) oldest() (uint16, bool) {
 	return i.ids[i.first], i.n > 0
 }
@@ -166,7 +175,8 @@ func newpacketlog() *packetlog {
 	chget := make(chan packidok)
 	chgetall := make(chan packidok)
 	chremove := make(chan packidok)
-	pl := packetlog{packets: pks, chadd: chadd, chget: chget, chgetall: chgetall, chremove: chremove}
+	done := make(chan bool)
+	pl := packetlog{packets: pks, chadd: chadd, chget: chget, chgetall: chgetall, chremove: chremove, done: done}
 	go pl.run()
 	return &pl
 }
@@ -174,6 +184,7 @@ func newpacketlog() *packetlog {
 type packetlog struct {
 	packets                          map[uint16]*packet
 	chadd, chget, chgetall, chremove chan packidok
+	done                             chan bool // Closed to stop run
 	Verbose                          bool
 }
 
@@ -199,10 +210,17 @@ func (p *packetlog) run() {
 		case pk := <-p.chremove:
 			// Return range of all current packets
 			delete(p.packets, pk.id)
+		case <-p.done:
+			return
 		}
 	}
 }
 
+// Stop the goroutine serving the log, it must not be used afterwards.
+func (p *packetlog) close() {
+	close(p.done)
+}
+
 func (p *packetlog) add(id uint16, pack *packet) {
 	pk := packidok{pack: pack, id: id}
 	p.chadd <- pk
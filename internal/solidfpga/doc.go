@@ -0,0 +1,10 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package solidfpga holds the register accessors generated by ipbusgen for
+// the 8 channel SoLid test address table, to check the generated code
+// builds and works.
+package solidfpga
+
+//go:generate go run ../../cmd/ipbusgen -type FPGA -o fpga.go ../../testdata/8chanxml/addr_table/top.xml
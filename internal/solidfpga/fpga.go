@@ -0,0 +1,2182 @@
+// Code generated by ipbusgen from top.xml. DO NOT EDIT.
+
+package solidfpga
+
+import (
+	"fmt"
+
+	"github.com/go-daq/ipbus"
+)
+
+// FPGA gives typed access to the registers of address table top.xml.
+type FPGA struct {
+	target ipbus.Target
+}
+
+// Check that the registers of target are those of top.xml and give access to them.
+func NewFPGA(target ipbus.Target) (FPGA, error) {
+	for name, addr := range fpgaAddrs {
+		reg, ok := target.Regs[name]
+		if !ok {
+			return FPGA{}, fmt.Errorf("Target %s has no register '%s'.", target.Name, name)
+		}
+		if reg.Addr != addr {
+			return FPGA{}, fmt.Errorf("Register '%s' of target %s is at 0x%x, expected 0x%x.", name, target.Name, reg.Addr, addr)
+		}
+	}
+	return FPGA{target}, nil
+}
+
+// Addresses of the registers in top.xml.
+var fpgaAddrs = map[string]uint32{
+	"ctrl_reg":               0x00000000,
+	"ctrl_reg.ctrl":          0x00000000,
+	"ctrl_reg.id":            0x00000002,
+	"ctrl_reg.stat":          0x00000003,
+	"chan":                   0x00000008,
+	"chan.csr":               0x00000008,
+	"chan.csr.ctrl":          0x00000008,
+	"chan.csr.stat":          0x00000009,
+	"chan.fifo":              0x0000000a,
+	"io":                     0x00000020,
+	"io.csr":                 0x00000020,
+	"io.csr.ctrl":            0x00000020,
+	"io.csr.stat":            0x00000021,
+	"io.freq_ctr":            0x00000024,
+	"io.freq_ctr.ctrl":       0x00000024,
+	"io.freq_ctr.freq":       0x00000025,
+	"io.clock_i2c":           0x00000028,
+	"io.clock_i2c.ps_lo":     0x00000028,
+	"io.clock_i2c.ps_hi":     0x00000029,
+	"io.clock_i2c.ctrl":      0x0000002a,
+	"io.clock_i2c.data":      0x0000002b,
+	"io.clock_i2c.cmd_stat":  0x0000002c,
+	"io.spi":                 0x00000030,
+	"io.spi.d0":              0x00000030,
+	"io.spi.d1":              0x00000031,
+	"io.spi.d2":              0x00000032,
+	"io.spi.d3":              0x00000033,
+	"io.spi.ctrl":            0x00000034,
+	"io.spi.divider":         0x00000035,
+	"io.spi.ss":              0x00000036,
+	"io.analog_i2c":          0x00000038,
+	"io.analog_i2c.ps_lo":    0x00000038,
+	"io.analog_i2c.ps_hi":    0x00000039,
+	"io.analog_i2c.ctrl":     0x0000003a,
+	"io.analog_i2c.data":     0x0000003b,
+	"io.analog_i2c.cmd_stat": 0x0000003c,
+	"timing":                 0x00000040,
+	"timing.csr":             0x00000040,
+	"timing.csr.ctrl":        0x00000040,
+	"timing.sctr":            0x00000042,
+	"timing.sctr.bottom":     0x00000042,
+	"timing.sctr.top":        0x00000043,
+}
+
+// CtrlReg is ctrl_reg at 0x00000000: ctrl/stat register.
+func (r FPGA) CtrlReg() FPGACtrlReg {
+	return FPGACtrlReg{r.target, r.target.Regs["ctrl_reg"]}
+}
+
+// Chan is chan at 0x00000008: channel controls.
+func (r FPGA) Chan() FPGAChan {
+	return FPGAChan{r.target, r.target.Regs["chan"]}
+}
+
+// Io is io at 0x00000020: IO controllers.
+func (r FPGA) Io() FPGAIo {
+	return FPGAIo{r.target, r.target.Regs["io"]}
+}
+
+// Timing is timing at 0x00000040: Sample clock domain control.
+func (r FPGA) Timing() FPGATiming {
+	return FPGATiming{r.target, r.target.Regs["timing"]}
+}
+
+// FPGACtrlReg is ctrl_reg.
+type FPGACtrlReg struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGACtrlReg) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGACtrlReg) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGACtrlReg) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Read nword words.
+func (r FPGACtrlReg) ReadBlock(nword uint) ([]uint32, error) {
+	return r.target.ReadNow(r.reg, nword)
+}
+
+// Write the words of data.
+func (r FPGACtrlReg) WriteBlock(data []uint32) error {
+	return r.target.WriteNow(r.reg, data)
+}
+
+// Ctrl is ctrl at 0x00000000.
+func (r FPGACtrlReg) Ctrl() FPGACtrlRegCtrl {
+	return FPGACtrlRegCtrl{r.target, r.target.Regs["ctrl_reg.ctrl"]}
+}
+
+// Id is id at 0x00000002.
+func (r FPGACtrlReg) Id() FPGACtrlRegId {
+	return FPGACtrlRegId{r.target, r.target.Regs["ctrl_reg.id"]}
+}
+
+// Stat is stat at 0x00000003.
+func (r FPGACtrlReg) Stat() FPGACtrlRegStat {
+	return FPGACtrlRegStat{r.target, r.target.Regs["ctrl_reg.stat"]}
+}
+
+// FPGACtrlRegCtrl is ctrl_reg.ctrl.
+type FPGACtrlRegCtrl struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGACtrlRegCtrl) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGACtrlRegCtrl) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGACtrlRegCtrl) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// SoftRst is soft_rst, mask 0x00000001.
+func (r FPGACtrlRegCtrl) SoftRst() FPGACtrlRegCtrlSoftRst {
+	return FPGACtrlRegCtrlSoftRst{r.target, r.reg}
+}
+
+// Nuke is nuke, mask 0x00000002.
+func (r FPGACtrlRegCtrl) Nuke() FPGACtrlRegCtrlNuke {
+	return FPGACtrlRegCtrlNuke{r.target, r.reg}
+}
+
+// RstMmcm is rst_mmcm, mask 0x00000004.
+func (r FPGACtrlRegCtrl) RstMmcm() FPGACtrlRegCtrlRstMmcm {
+	return FPGACtrlRegCtrlRstMmcm{r.target, r.reg}
+}
+
+// RstIdelayctrl is rst_idelayctrl, mask 0x00000008.
+func (r FPGACtrlRegCtrl) RstIdelayctrl() FPGACtrlRegCtrlRstIdelayctrl {
+	return FPGACtrlRegCtrlRstIdelayctrl{r.target, r.reg}
+}
+
+// Chan is chan, mask 0x0000ff00.
+func (r FPGACtrlRegCtrl) Chan() FPGACtrlRegCtrlChan {
+	return FPGACtrlRegCtrlChan{r.target, r.reg}
+}
+
+// BoardId is board_id, mask 0x00ff0000.
+func (r FPGACtrlRegCtrl) BoardId() FPGACtrlRegCtrlBoardId {
+	return FPGACtrlRegCtrlBoardId{r.target, r.reg}
+}
+
+// FPGACtrlRegCtrlSoftRst is ctrl_reg.ctrl.soft_rst.
+type FPGACtrlRegCtrlSoftRst struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGACtrlRegCtrlSoftRst) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "soft_rst")
+}
+
+// Write value to the masked bits.
+func (r FPGACtrlRegCtrlSoftRst) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "soft_rst", value)
+	return err
+}
+
+// FPGACtrlRegCtrlNuke is ctrl_reg.ctrl.nuke.
+type FPGACtrlRegCtrlNuke struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGACtrlRegCtrlNuke) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "nuke")
+}
+
+// Write value to the masked bits.
+func (r FPGACtrlRegCtrlNuke) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "nuke", value)
+	return err
+}
+
+// FPGACtrlRegCtrlRstMmcm is ctrl_reg.ctrl.rst_mmcm.
+type FPGACtrlRegCtrlRstMmcm struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGACtrlRegCtrlRstMmcm) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "rst_mmcm")
+}
+
+// Write value to the masked bits.
+func (r FPGACtrlRegCtrlRstMmcm) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "rst_mmcm", value)
+	return err
+}
+
+// FPGACtrlRegCtrlRstIdelayctrl is ctrl_reg.ctrl.rst_idelayctrl.
+type FPGACtrlRegCtrlRstIdelayctrl struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGACtrlRegCtrlRstIdelayctrl) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "rst_idelayctrl")
+}
+
+// Write value to the masked bits.
+func (r FPGACtrlRegCtrlRstIdelayctrl) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "rst_idelayctrl", value)
+	return err
+}
+
+// FPGACtrlRegCtrlChan is ctrl_reg.ctrl.chan.
+type FPGACtrlRegCtrlChan struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGACtrlRegCtrlChan) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "chan")
+}
+
+// Write value to the masked bits.
+func (r FPGACtrlRegCtrlChan) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "chan", value)
+	return err
+}
+
+// FPGACtrlRegCtrlBoardId is ctrl_reg.ctrl.board_id.
+type FPGACtrlRegCtrlBoardId struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGACtrlRegCtrlBoardId) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "board_id")
+}
+
+// Write value to the masked bits.
+func (r FPGACtrlRegCtrlBoardId) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "board_id", value)
+	return err
+}
+
+// FPGACtrlRegId is ctrl_reg.id.
+type FPGACtrlRegId struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGACtrlRegId) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGACtrlRegId) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGACtrlRegId) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGACtrlRegStat is ctrl_reg.stat.
+type FPGACtrlRegStat struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGACtrlRegStat) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGACtrlRegStat) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGACtrlRegStat) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// MmcmLocked is mmcm_locked, mask 0x00000001.
+func (r FPGACtrlRegStat) MmcmLocked() FPGACtrlRegStatMmcmLocked {
+	return FPGACtrlRegStatMmcmLocked{r.target, r.reg}
+}
+
+// IdelayctrlRdy is idelayctrl_rdy, mask 0x00000002.
+func (r FPGACtrlRegStat) IdelayctrlRdy() FPGACtrlRegStatIdelayctrlRdy {
+	return FPGACtrlRegStatIdelayctrlRdy{r.target, r.reg}
+}
+
+// FPGACtrlRegStatMmcmLocked is ctrl_reg.stat.mmcm_locked.
+type FPGACtrlRegStatMmcmLocked struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGACtrlRegStatMmcmLocked) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "mmcm_locked")
+}
+
+// Write value to the masked bits.
+func (r FPGACtrlRegStatMmcmLocked) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "mmcm_locked", value)
+	return err
+}
+
+// FPGACtrlRegStatIdelayctrlRdy is ctrl_reg.stat.idelayctrl_rdy.
+type FPGACtrlRegStatIdelayctrlRdy struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGACtrlRegStatIdelayctrlRdy) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "idelayctrl_rdy")
+}
+
+// Write value to the masked bits.
+func (r FPGACtrlRegStatIdelayctrlRdy) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "idelayctrl_rdy", value)
+	return err
+}
+
+// FPGAChan is chan.
+type FPGAChan struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAChan) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAChan) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAChan) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Csr is csr at 0x00000008: ctrl/status register.
+func (r FPGAChan) Csr() FPGAChanCsr {
+	return FPGAChanCsr{r.target, r.target.Regs["chan.csr"]}
+}
+
+// Fifo is fifo at 0x0000000a: channel FIFO.
+func (r FPGAChan) Fifo() FPGAChanFifo {
+	return FPGAChanFifo{r.target, r.target.Regs["chan.fifo"]}
+}
+
+// FPGAChanCsr is chan.csr.
+type FPGAChanCsr struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAChanCsr) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAChanCsr) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAChanCsr) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Read nword words.
+func (r FPGAChanCsr) ReadBlock(nword uint) ([]uint32, error) {
+	return r.target.ReadNow(r.reg, nword)
+}
+
+// Write the words of data.
+func (r FPGAChanCsr) WriteBlock(data []uint32) error {
+	return r.target.WriteNow(r.reg, data)
+}
+
+// Ctrl is ctrl at 0x00000008.
+func (r FPGAChanCsr) Ctrl() FPGAChanCsrCtrl {
+	return FPGAChanCsrCtrl{r.target, r.target.Regs["chan.csr.ctrl"]}
+}
+
+// Stat is stat at 0x00000009.
+func (r FPGAChanCsr) Stat() FPGAChanCsrStat {
+	return FPGAChanCsrStat{r.target, r.target.Regs["chan.csr.stat"]}
+}
+
+// FPGAChanCsrCtrl is chan.csr.ctrl.
+type FPGAChanCsrCtrl struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAChanCsrCtrl) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAChanCsrCtrl) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAChanCsrCtrl) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// EnSync is en_sync, mask 0x00000001.
+func (r FPGAChanCsrCtrl) EnSync() FPGAChanCsrCtrlEnSync {
+	return FPGAChanCsrCtrlEnSync{r.target, r.reg}
+}
+
+// EnComp is en_comp, mask 0x00000002.
+func (r FPGAChanCsrCtrl) EnComp() FPGAChanCsrCtrlEnComp {
+	return FPGAChanCsrCtrlEnComp{r.target, r.reg}
+}
+
+// Patt is patt, mask 0x3fff0000.
+func (r FPGAChanCsrCtrl) Patt() FPGAChanCsrCtrlPatt {
+	return FPGAChanCsrCtrlPatt{r.target, r.reg}
+}
+
+// FPGAChanCsrCtrlEnSync is chan.csr.ctrl.en_sync.
+type FPGAChanCsrCtrlEnSync struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAChanCsrCtrlEnSync) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "en_sync")
+}
+
+// Write value to the masked bits.
+func (r FPGAChanCsrCtrlEnSync) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "en_sync", value)
+	return err
+}
+
+// FPGAChanCsrCtrlEnComp is chan.csr.ctrl.en_comp.
+type FPGAChanCsrCtrlEnComp struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAChanCsrCtrlEnComp) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "en_comp")
+}
+
+// Write value to the masked bits.
+func (r FPGAChanCsrCtrlEnComp) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "en_comp", value)
+	return err
+}
+
+// FPGAChanCsrCtrlPatt is chan.csr.ctrl.patt.
+type FPGAChanCsrCtrlPatt struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAChanCsrCtrlPatt) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "patt")
+}
+
+// Write value to the masked bits.
+func (r FPGAChanCsrCtrlPatt) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "patt", value)
+	return err
+}
+
+// FPGAChanCsrStat is chan.csr.stat.
+type FPGAChanCsrStat struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAChanCsrStat) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAChanCsrStat) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAChanCsrStat) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Empty is empty, mask 0x00000001.
+func (r FPGAChanCsrStat) Empty() FPGAChanCsrStatEmpty {
+	return FPGAChanCsrStatEmpty{r.target, r.reg}
+}
+
+// Full is full, mask 0x00000002.
+func (r FPGAChanCsrStat) Full() FPGAChanCsrStatFull {
+	return FPGAChanCsrStatFull{r.target, r.reg}
+}
+
+// ErrCnt is err_cnt, mask 0xffff0000.
+func (r FPGAChanCsrStat) ErrCnt() FPGAChanCsrStatErrCnt {
+	return FPGAChanCsrStatErrCnt{r.target, r.reg}
+}
+
+// FPGAChanCsrStatEmpty is chan.csr.stat.empty.
+type FPGAChanCsrStatEmpty struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAChanCsrStatEmpty) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "empty")
+}
+
+// Write value to the masked bits.
+func (r FPGAChanCsrStatEmpty) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "empty", value)
+	return err
+}
+
+// FPGAChanCsrStatFull is chan.csr.stat.full.
+type FPGAChanCsrStatFull struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAChanCsrStatFull) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "full")
+}
+
+// Write value to the masked bits.
+func (r FPGAChanCsrStatFull) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "full", value)
+	return err
+}
+
+// FPGAChanCsrStatErrCnt is chan.csr.stat.err_cnt.
+type FPGAChanCsrStatErrCnt struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAChanCsrStatErrCnt) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "err_cnt")
+}
+
+// Write value to the masked bits.
+func (r FPGAChanCsrStatErrCnt) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "err_cnt", value)
+	return err
+}
+
+// FPGAChanFifo is chan.fifo.
+type FPGAChanFifo struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAChanFifo) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAChanFifo) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAChanFifo) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Read nword words.
+func (r FPGAChanFifo) ReadBlock(nword uint) ([]uint32, error) {
+	return r.target.ReadNow(r.reg, nword)
+}
+
+// Write the words of data.
+func (r FPGAChanFifo) WriteBlock(data []uint32) error {
+	return r.target.WriteNow(r.reg, data)
+}
+
+// FPGAIo is io.
+type FPGAIo struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIo) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIo) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIo) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Csr is csr at 0x00000020: ctrl/stat register.
+func (r FPGAIo) Csr() FPGAIoCsr {
+	return FPGAIoCsr{r.target, r.target.Regs["io.csr"]}
+}
+
+// FreqCtr is freq_ctr at 0x00000024: Frequency counter.
+func (r FPGAIo) FreqCtr() FPGAIoFreqCtr {
+	return FPGAIoFreqCtr{r.target, r.target.Regs["io.freq_ctr"]}
+}
+
+// ClockI2c is clock_i2c at 0x00000028: I2C master controller.
+func (r FPGAIo) ClockI2c() FPGAIoClockI2c {
+	return FPGAIoClockI2c{r.target, r.target.Regs["io.clock_i2c"]}
+}
+
+// Spi is spi at 0x00000030: SPI master controller.
+func (r FPGAIo) Spi() FPGAIoSpi {
+	return FPGAIoSpi{r.target, r.target.Regs["io.spi"]}
+}
+
+// AnalogI2c is analog_i2c at 0x00000038: I2C master controller.
+func (r FPGAIo) AnalogI2c() FPGAIoAnalogI2c {
+	return FPGAIoAnalogI2c{r.target, r.target.Regs["io.analog_i2c"]}
+}
+
+// FPGAIoCsr is io.csr.
+type FPGAIoCsr struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoCsr) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoCsr) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoCsr) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Read nword words.
+func (r FPGAIoCsr) ReadBlock(nword uint) ([]uint32, error) {
+	return r.target.ReadNow(r.reg, nword)
+}
+
+// Write the words of data.
+func (r FPGAIoCsr) WriteBlock(data []uint32) error {
+	return r.target.WriteNow(r.reg, data)
+}
+
+// Ctrl is ctrl at 0x00000020.
+func (r FPGAIoCsr) Ctrl() FPGAIoCsrCtrl {
+	return FPGAIoCsrCtrl{r.target, r.target.Regs["io.csr.ctrl"]}
+}
+
+// Stat is stat at 0x00000021.
+func (r FPGAIoCsr) Stat() FPGAIoCsrStat {
+	return FPGAIoCsrStat{r.target, r.target.Regs["io.csr.stat"]}
+}
+
+// FPGAIoCsrCtrl is io.csr.ctrl.
+type FPGAIoCsrCtrl struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoCsrCtrl) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoCsrCtrl) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoCsrCtrl) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Si5326Rst is si5326_rst, mask 0x00000001.
+func (r FPGAIoCsrCtrl) Si5326Rst() FPGAIoCsrCtrlSi5326Rst {
+	return FPGAIoCsrCtrlSi5326Rst{r.target, r.reg}
+}
+
+// Si5326ClkSel is si5326_clk_sel, mask 0x00000002.
+func (r FPGAIoCsrCtrl) Si5326ClkSel() FPGAIoCsrCtrlSi5326ClkSel {
+	return FPGAIoCsrCtrlSi5326ClkSel{r.target, r.reg}
+}
+
+// Si5326Rate0 is si5326_rate0, mask 0x00000004.
+func (r FPGAIoCsrCtrl) Si5326Rate0() FPGAIoCsrCtrlSi5326Rate0 {
+	return FPGAIoCsrCtrlSi5326Rate0{r.target, r.reg}
+}
+
+// Si5326Rate1 is si5326_rate1, mask 0x00000008.
+func (r FPGAIoCsrCtrl) Si5326Rate1() FPGAIoCsrCtrlSi5326Rate1 {
+	return FPGAIoCsrCtrlSi5326Rate1{r.target, r.reg}
+}
+
+// FPGAIoCsrCtrlSi5326Rst is io.csr.ctrl.si5326_rst.
+type FPGAIoCsrCtrlSi5326Rst struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAIoCsrCtrlSi5326Rst) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "si5326_rst")
+}
+
+// Write value to the masked bits.
+func (r FPGAIoCsrCtrlSi5326Rst) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "si5326_rst", value)
+	return err
+}
+
+// FPGAIoCsrCtrlSi5326ClkSel is io.csr.ctrl.si5326_clk_sel.
+type FPGAIoCsrCtrlSi5326ClkSel struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAIoCsrCtrlSi5326ClkSel) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "si5326_clk_sel")
+}
+
+// Write value to the masked bits.
+func (r FPGAIoCsrCtrlSi5326ClkSel) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "si5326_clk_sel", value)
+	return err
+}
+
+// FPGAIoCsrCtrlSi5326Rate0 is io.csr.ctrl.si5326_rate0.
+type FPGAIoCsrCtrlSi5326Rate0 struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAIoCsrCtrlSi5326Rate0) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "si5326_rate0")
+}
+
+// Write value to the masked bits.
+func (r FPGAIoCsrCtrlSi5326Rate0) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "si5326_rate0", value)
+	return err
+}
+
+// FPGAIoCsrCtrlSi5326Rate1 is io.csr.ctrl.si5326_rate1.
+type FPGAIoCsrCtrlSi5326Rate1 struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAIoCsrCtrlSi5326Rate1) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "si5326_rate1")
+}
+
+// Write value to the masked bits.
+func (r FPGAIoCsrCtrlSi5326Rate1) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "si5326_rate1", value)
+	return err
+}
+
+// FPGAIoCsrStat is io.csr.stat.
+type FPGAIoCsrStat struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoCsrStat) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoCsrStat) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoCsrStat) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Si5326Lol is si5326_lol, mask 0x00000001.
+func (r FPGAIoCsrStat) Si5326Lol() FPGAIoCsrStatSi5326Lol {
+	return FPGAIoCsrStatSi5326Lol{r.target, r.reg}
+}
+
+// Si5326Clk1Validn is si5326_clk1_validn, mask 0x00000002.
+func (r FPGAIoCsrStat) Si5326Clk1Validn() FPGAIoCsrStatSi5326Clk1Validn {
+	return FPGAIoCsrStatSi5326Clk1Validn{r.target, r.reg}
+}
+
+// Si5326Clk2Validn is si5326_clk2_validn, mask 0x00000004.
+func (r FPGAIoCsrStat) Si5326Clk2Validn() FPGAIoCsrStatSi5326Clk2Validn {
+	return FPGAIoCsrStatSi5326Clk2Validn{r.target, r.reg}
+}
+
+// FPGAIoCsrStatSi5326Lol is io.csr.stat.si5326_lol.
+type FPGAIoCsrStatSi5326Lol struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAIoCsrStatSi5326Lol) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "si5326_lol")
+}
+
+// Write value to the masked bits.
+func (r FPGAIoCsrStatSi5326Lol) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "si5326_lol", value)
+	return err
+}
+
+// FPGAIoCsrStatSi5326Clk1Validn is io.csr.stat.si5326_clk1_validn.
+type FPGAIoCsrStatSi5326Clk1Validn struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAIoCsrStatSi5326Clk1Validn) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "si5326_clk1_validn")
+}
+
+// Write value to the masked bits.
+func (r FPGAIoCsrStatSi5326Clk1Validn) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "si5326_clk1_validn", value)
+	return err
+}
+
+// FPGAIoCsrStatSi5326Clk2Validn is io.csr.stat.si5326_clk2_validn.
+type FPGAIoCsrStatSi5326Clk2Validn struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAIoCsrStatSi5326Clk2Validn) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "si5326_clk2_validn")
+}
+
+// Write value to the masked bits.
+func (r FPGAIoCsrStatSi5326Clk2Validn) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "si5326_clk2_validn", value)
+	return err
+}
+
+// FPGAIoFreqCtr is io.freq_ctr.
+type FPGAIoFreqCtr struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoFreqCtr) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoFreqCtr) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoFreqCtr) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Read nword words.
+func (r FPGAIoFreqCtr) ReadBlock(nword uint) ([]uint32, error) {
+	return r.target.ReadNow(r.reg, nword)
+}
+
+// Write the words of data.
+func (r FPGAIoFreqCtr) WriteBlock(data []uint32) error {
+	return r.target.WriteNow(r.reg, data)
+}
+
+// Ctrl is ctrl at 0x00000024.
+func (r FPGAIoFreqCtr) Ctrl() FPGAIoFreqCtrCtrl {
+	return FPGAIoFreqCtrCtrl{r.target, r.target.Regs["io.freq_ctr.ctrl"]}
+}
+
+// Freq is freq at 0x00000025.
+func (r FPGAIoFreqCtr) Freq() FPGAIoFreqCtrFreq {
+	return FPGAIoFreqCtrFreq{r.target, r.target.Regs["io.freq_ctr.freq"]}
+}
+
+// FPGAIoFreqCtrCtrl is io.freq_ctr.ctrl.
+type FPGAIoFreqCtrCtrl struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoFreqCtrCtrl) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoFreqCtrCtrl) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoFreqCtrCtrl) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// ChanSel is chan_sel, mask 0x0000000f.
+func (r FPGAIoFreqCtrCtrl) ChanSel() FPGAIoFreqCtrCtrlChanSel {
+	return FPGAIoFreqCtrCtrlChanSel{r.target, r.reg}
+}
+
+// EnCrapMode is en_crap_mode, mask 0x00000010.
+func (r FPGAIoFreqCtrCtrl) EnCrapMode() FPGAIoFreqCtrCtrlEnCrapMode {
+	return FPGAIoFreqCtrCtrlEnCrapMode{r.target, r.reg}
+}
+
+// FPGAIoFreqCtrCtrlChanSel is io.freq_ctr.ctrl.chan_sel.
+type FPGAIoFreqCtrCtrlChanSel struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAIoFreqCtrCtrlChanSel) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "chan_sel")
+}
+
+// Write value to the masked bits.
+func (r FPGAIoFreqCtrCtrlChanSel) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "chan_sel", value)
+	return err
+}
+
+// FPGAIoFreqCtrCtrlEnCrapMode is io.freq_ctr.ctrl.en_crap_mode.
+type FPGAIoFreqCtrCtrlEnCrapMode struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAIoFreqCtrCtrlEnCrapMode) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "en_crap_mode")
+}
+
+// Write value to the masked bits.
+func (r FPGAIoFreqCtrCtrlEnCrapMode) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "en_crap_mode", value)
+	return err
+}
+
+// FPGAIoFreqCtrFreq is io.freq_ctr.freq.
+type FPGAIoFreqCtrFreq struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoFreqCtrFreq) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoFreqCtrFreq) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoFreqCtrFreq) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Count is count, mask 0x00ffffff.
+func (r FPGAIoFreqCtrFreq) Count() FPGAIoFreqCtrFreqCount {
+	return FPGAIoFreqCtrFreqCount{r.target, r.reg}
+}
+
+// Valid is valid, mask 0x01000000.
+func (r FPGAIoFreqCtrFreq) Valid() FPGAIoFreqCtrFreqValid {
+	return FPGAIoFreqCtrFreqValid{r.target, r.reg}
+}
+
+// FPGAIoFreqCtrFreqCount is io.freq_ctr.freq.count.
+type FPGAIoFreqCtrFreqCount struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAIoFreqCtrFreqCount) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "count")
+}
+
+// Write value to the masked bits.
+func (r FPGAIoFreqCtrFreqCount) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "count", value)
+	return err
+}
+
+// FPGAIoFreqCtrFreqValid is io.freq_ctr.freq.valid.
+type FPGAIoFreqCtrFreqValid struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGAIoFreqCtrFreqValid) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "valid")
+}
+
+// Write value to the masked bits.
+func (r FPGAIoFreqCtrFreqValid) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "valid", value)
+	return err
+}
+
+// FPGAIoClockI2c is io.clock_i2c.
+type FPGAIoClockI2c struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoClockI2c) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoClockI2c) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoClockI2c) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Read nword words.
+func (r FPGAIoClockI2c) ReadBlock(nword uint) ([]uint32, error) {
+	return r.target.ReadNow(r.reg, nword)
+}
+
+// Write the words of data.
+func (r FPGAIoClockI2c) WriteBlock(data []uint32) error {
+	return r.target.WriteNow(r.reg, data)
+}
+
+// PsLo is ps_lo at 0x00000028: Prescale low byte.
+func (r FPGAIoClockI2c) PsLo() FPGAIoClockI2cPsLo {
+	return FPGAIoClockI2cPsLo{r.target, r.target.Regs["io.clock_i2c.ps_lo"]}
+}
+
+// PsHi is ps_hi at 0x00000029: Prescale low byte.
+func (r FPGAIoClockI2c) PsHi() FPGAIoClockI2cPsHi {
+	return FPGAIoClockI2cPsHi{r.target, r.target.Regs["io.clock_i2c.ps_hi"]}
+}
+
+// Ctrl is ctrl at 0x0000002a: Control.
+func (r FPGAIoClockI2c) Ctrl() FPGAIoClockI2cCtrl {
+	return FPGAIoClockI2cCtrl{r.target, r.target.Regs["io.clock_i2c.ctrl"]}
+}
+
+// Data is data at 0x0000002b: Data.
+func (r FPGAIoClockI2c) Data() FPGAIoClockI2cData {
+	return FPGAIoClockI2cData{r.target, r.target.Regs["io.clock_i2c.data"]}
+}
+
+// CmdStat is cmd_stat at 0x0000002c: Command / status.
+func (r FPGAIoClockI2c) CmdStat() FPGAIoClockI2cCmdStat {
+	return FPGAIoClockI2cCmdStat{r.target, r.target.Regs["io.clock_i2c.cmd_stat"]}
+}
+
+// FPGAIoClockI2cPsLo is io.clock_i2c.ps_lo.
+type FPGAIoClockI2cPsLo struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoClockI2cPsLo) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoClockI2cPsLo) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoClockI2cPsLo) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoClockI2cPsHi is io.clock_i2c.ps_hi.
+type FPGAIoClockI2cPsHi struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoClockI2cPsHi) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoClockI2cPsHi) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoClockI2cPsHi) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoClockI2cCtrl is io.clock_i2c.ctrl.
+type FPGAIoClockI2cCtrl struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoClockI2cCtrl) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoClockI2cCtrl) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoClockI2cCtrl) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoClockI2cData is io.clock_i2c.data.
+type FPGAIoClockI2cData struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoClockI2cData) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoClockI2cData) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoClockI2cData) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoClockI2cCmdStat is io.clock_i2c.cmd_stat.
+type FPGAIoClockI2cCmdStat struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoClockI2cCmdStat) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoClockI2cCmdStat) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoClockI2cCmdStat) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoSpi is io.spi.
+type FPGAIoSpi struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoSpi) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoSpi) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoSpi) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Read nword words.
+func (r FPGAIoSpi) ReadBlock(nword uint) ([]uint32, error) {
+	return r.target.ReadNow(r.reg, nword)
+}
+
+// Write the words of data.
+func (r FPGAIoSpi) WriteBlock(data []uint32) error {
+	return r.target.WriteNow(r.reg, data)
+}
+
+// D0 is d0 at 0x00000030: Data reg 0.
+func (r FPGAIoSpi) D0() FPGAIoSpiD0 {
+	return FPGAIoSpiD0{r.target, r.target.Regs["io.spi.d0"]}
+}
+
+// D1 is d1 at 0x00000031: Data reg 1.
+func (r FPGAIoSpi) D1() FPGAIoSpiD1 {
+	return FPGAIoSpiD1{r.target, r.target.Regs["io.spi.d1"]}
+}
+
+// D2 is d2 at 0x00000032: Data reg 2.
+func (r FPGAIoSpi) D2() FPGAIoSpiD2 {
+	return FPGAIoSpiD2{r.target, r.target.Regs["io.spi.d2"]}
+}
+
+// D3 is d3 at 0x00000033: Data reg 3.
+func (r FPGAIoSpi) D3() FPGAIoSpiD3 {
+	return FPGAIoSpiD3{r.target, r.target.Regs["io.spi.d3"]}
+}
+
+// Ctrl is ctrl at 0x00000034: Control reg.
+func (r FPGAIoSpi) Ctrl() FPGAIoSpiCtrl {
+	return FPGAIoSpiCtrl{r.target, r.target.Regs["io.spi.ctrl"]}
+}
+
+// Divider is divider at 0x00000035: Clock divider reg.
+func (r FPGAIoSpi) Divider() FPGAIoSpiDivider {
+	return FPGAIoSpiDivider{r.target, r.target.Regs["io.spi.divider"]}
+}
+
+// Ss is ss at 0x00000036: Slave select reg.
+func (r FPGAIoSpi) Ss() FPGAIoSpiSs {
+	return FPGAIoSpiSs{r.target, r.target.Regs["io.spi.ss"]}
+}
+
+// FPGAIoSpiD0 is io.spi.d0.
+type FPGAIoSpiD0 struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoSpiD0) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoSpiD0) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoSpiD0) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoSpiD1 is io.spi.d1.
+type FPGAIoSpiD1 struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoSpiD1) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoSpiD1) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoSpiD1) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoSpiD2 is io.spi.d2.
+type FPGAIoSpiD2 struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoSpiD2) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoSpiD2) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoSpiD2) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoSpiD3 is io.spi.d3.
+type FPGAIoSpiD3 struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoSpiD3) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoSpiD3) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoSpiD3) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoSpiCtrl is io.spi.ctrl.
+type FPGAIoSpiCtrl struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoSpiCtrl) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoSpiCtrl) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoSpiCtrl) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoSpiDivider is io.spi.divider.
+type FPGAIoSpiDivider struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoSpiDivider) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoSpiDivider) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoSpiDivider) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoSpiSs is io.spi.ss.
+type FPGAIoSpiSs struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoSpiSs) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoSpiSs) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoSpiSs) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoAnalogI2c is io.analog_i2c.
+type FPGAIoAnalogI2c struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoAnalogI2c) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoAnalogI2c) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoAnalogI2c) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Read nword words.
+func (r FPGAIoAnalogI2c) ReadBlock(nword uint) ([]uint32, error) {
+	return r.target.ReadNow(r.reg, nword)
+}
+
+// Write the words of data.
+func (r FPGAIoAnalogI2c) WriteBlock(data []uint32) error {
+	return r.target.WriteNow(r.reg, data)
+}
+
+// PsLo is ps_lo at 0x00000038: Prescale low byte.
+func (r FPGAIoAnalogI2c) PsLo() FPGAIoAnalogI2cPsLo {
+	return FPGAIoAnalogI2cPsLo{r.target, r.target.Regs["io.analog_i2c.ps_lo"]}
+}
+
+// PsHi is ps_hi at 0x00000039: Prescale low byte.
+func (r FPGAIoAnalogI2c) PsHi() FPGAIoAnalogI2cPsHi {
+	return FPGAIoAnalogI2cPsHi{r.target, r.target.Regs["io.analog_i2c.ps_hi"]}
+}
+
+// Ctrl is ctrl at 0x0000003a: Control.
+func (r FPGAIoAnalogI2c) Ctrl() FPGAIoAnalogI2cCtrl {
+	return FPGAIoAnalogI2cCtrl{r.target, r.target.Regs["io.analog_i2c.ctrl"]}
+}
+
+// Data is data at 0x0000003b: Data.
+func (r FPGAIoAnalogI2c) Data() FPGAIoAnalogI2cData {
+	return FPGAIoAnalogI2cData{r.target, r.target.Regs["io.analog_i2c.data"]}
+}
+
+// CmdStat is cmd_stat at 0x0000003c: Command / status.
+func (r FPGAIoAnalogI2c) CmdStat() FPGAIoAnalogI2cCmdStat {
+	return FPGAIoAnalogI2cCmdStat{r.target, r.target.Regs["io.analog_i2c.cmd_stat"]}
+}
+
+// FPGAIoAnalogI2cPsLo is io.analog_i2c.ps_lo.
+type FPGAIoAnalogI2cPsLo struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoAnalogI2cPsLo) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoAnalogI2cPsLo) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoAnalogI2cPsLo) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoAnalogI2cPsHi is io.analog_i2c.ps_hi.
+type FPGAIoAnalogI2cPsHi struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoAnalogI2cPsHi) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoAnalogI2cPsHi) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoAnalogI2cPsHi) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoAnalogI2cCtrl is io.analog_i2c.ctrl.
+type FPGAIoAnalogI2cCtrl struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoAnalogI2cCtrl) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoAnalogI2cCtrl) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoAnalogI2cCtrl) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoAnalogI2cData is io.analog_i2c.data.
+type FPGAIoAnalogI2cData struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoAnalogI2cData) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoAnalogI2cData) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoAnalogI2cData) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGAIoAnalogI2cCmdStat is io.analog_i2c.cmd_stat.
+type FPGAIoAnalogI2cCmdStat struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGAIoAnalogI2cCmdStat) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGAIoAnalogI2cCmdStat) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGAIoAnalogI2cCmdStat) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGATiming is timing.
+type FPGATiming struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGATiming) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGATiming) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGATiming) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Csr is csr at 0x00000040: ctrl/status register.
+func (r FPGATiming) Csr() FPGATimingCsr {
+	return FPGATimingCsr{r.target, r.target.Regs["timing.csr"]}
+}
+
+// Sctr is sctr at 0x00000042: local sample counter.
+func (r FPGATiming) Sctr() FPGATimingSctr {
+	return FPGATimingSctr{r.target, r.target.Regs["timing.sctr"]}
+}
+
+// FPGATimingCsr is timing.csr.
+type FPGATimingCsr struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGATimingCsr) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGATimingCsr) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGATimingCsr) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Read nword words.
+func (r FPGATimingCsr) ReadBlock(nword uint) ([]uint32, error) {
+	return r.target.ReadNow(r.reg, nword)
+}
+
+// Write the words of data.
+func (r FPGATimingCsr) WriteBlock(data []uint32) error {
+	return r.target.WriteNow(r.reg, data)
+}
+
+// Ctrl is ctrl at 0x00000040.
+func (r FPGATimingCsr) Ctrl() FPGATimingCsrCtrl {
+	return FPGATimingCsrCtrl{r.target, r.target.Regs["timing.csr.ctrl"]}
+}
+
+// FPGATimingCsrCtrl is timing.csr.ctrl.
+type FPGATimingCsrCtrl struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGATimingCsrCtrl) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGATimingCsrCtrl) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGATimingCsrCtrl) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Rst is rst, mask 0x00000001.
+func (r FPGATimingCsrCtrl) Rst() FPGATimingCsrCtrlRst {
+	return FPGATimingCsrCtrlRst{r.target, r.reg}
+}
+
+// RstCtr is rst_ctr, mask 0x00000002.
+func (r FPGATimingCsrCtrl) RstCtr() FPGATimingCsrCtrlRstCtr {
+	return FPGATimingCsrCtrlRstCtr{r.target, r.reg}
+}
+
+// CapCtr is cap_ctr, mask 0x00000004.
+func (r FPGATimingCsrCtrl) CapCtr() FPGATimingCsrCtrlCapCtr {
+	return FPGATimingCsrCtrlCapCtr{r.target, r.reg}
+}
+
+// EnSync is en_sync, mask 0x00000008.
+func (r FPGATimingCsrCtrl) EnSync() FPGATimingCsrCtrlEnSync {
+	return FPGATimingCsrCtrlEnSync{r.target, r.reg}
+}
+
+// ForceSync is force_sync, mask 0x00000010.
+func (r FPGATimingCsrCtrl) ForceSync() FPGATimingCsrCtrlForceSync {
+	return FPGATimingCsrCtrlForceSync{r.target, r.reg}
+}
+
+// ChanSlip is chan_slip, mask 0x00001000.
+func (r FPGATimingCsrCtrl) ChanSlip() FPGATimingCsrCtrlChanSlip {
+	return FPGATimingCsrCtrlChanSlip{r.target, r.reg}
+}
+
+// ChanRstBuf is chan_rst_buf, mask 0x00002000.
+func (r FPGATimingCsrCtrl) ChanRstBuf() FPGATimingCsrCtrlChanRstBuf {
+	return FPGATimingCsrCtrlChanRstBuf{r.target, r.reg}
+}
+
+// ChanCap is chan_cap, mask 0x00004000.
+func (r FPGATimingCsrCtrl) ChanCap() FPGATimingCsrCtrlChanCap {
+	return FPGATimingCsrCtrlChanCap{r.target, r.reg}
+}
+
+// ChanInc is chan_inc, mask 0x00008000.
+func (r FPGATimingCsrCtrl) ChanInc() FPGATimingCsrCtrlChanInc {
+	return FPGATimingCsrCtrlChanInc{r.target, r.reg}
+}
+
+// FPGATimingCsrCtrlRst is timing.csr.ctrl.rst.
+type FPGATimingCsrCtrlRst struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGATimingCsrCtrlRst) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "rst")
+}
+
+// Write value to the masked bits.
+func (r FPGATimingCsrCtrlRst) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "rst", value)
+	return err
+}
+
+// FPGATimingCsrCtrlRstCtr is timing.csr.ctrl.rst_ctr.
+type FPGATimingCsrCtrlRstCtr struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGATimingCsrCtrlRstCtr) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "rst_ctr")
+}
+
+// Write value to the masked bits.
+func (r FPGATimingCsrCtrlRstCtr) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "rst_ctr", value)
+	return err
+}
+
+// FPGATimingCsrCtrlCapCtr is timing.csr.ctrl.cap_ctr.
+type FPGATimingCsrCtrlCapCtr struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGATimingCsrCtrlCapCtr) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "cap_ctr")
+}
+
+// Write value to the masked bits.
+func (r FPGATimingCsrCtrlCapCtr) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "cap_ctr", value)
+	return err
+}
+
+// FPGATimingCsrCtrlEnSync is timing.csr.ctrl.en_sync.
+type FPGATimingCsrCtrlEnSync struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGATimingCsrCtrlEnSync) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "en_sync")
+}
+
+// Write value to the masked bits.
+func (r FPGATimingCsrCtrlEnSync) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "en_sync", value)
+	return err
+}
+
+// FPGATimingCsrCtrlForceSync is timing.csr.ctrl.force_sync.
+type FPGATimingCsrCtrlForceSync struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGATimingCsrCtrlForceSync) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "force_sync")
+}
+
+// Write value to the masked bits.
+func (r FPGATimingCsrCtrlForceSync) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "force_sync", value)
+	return err
+}
+
+// FPGATimingCsrCtrlChanSlip is timing.csr.ctrl.chan_slip.
+type FPGATimingCsrCtrlChanSlip struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGATimingCsrCtrlChanSlip) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "chan_slip")
+}
+
+// Write value to the masked bits.
+func (r FPGATimingCsrCtrlChanSlip) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "chan_slip", value)
+	return err
+}
+
+// FPGATimingCsrCtrlChanRstBuf is timing.csr.ctrl.chan_rst_buf.
+type FPGATimingCsrCtrlChanRstBuf struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGATimingCsrCtrlChanRstBuf) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "chan_rst_buf")
+}
+
+// Write value to the masked bits.
+func (r FPGATimingCsrCtrlChanRstBuf) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "chan_rst_buf", value)
+	return err
+}
+
+// FPGATimingCsrCtrlChanCap is timing.csr.ctrl.chan_cap.
+type FPGATimingCsrCtrlChanCap struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGATimingCsrCtrlChanCap) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "chan_cap")
+}
+
+// Write value to the masked bits.
+func (r FPGATimingCsrCtrlChanCap) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "chan_cap", value)
+	return err
+}
+
+// FPGATimingCsrCtrlChanInc is timing.csr.ctrl.chan_inc.
+type FPGATimingCsrCtrlChanInc struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// Read the value of the masked bits.
+func (r FPGATimingCsrCtrlChanInc) Read() (uint32, error) {
+	return r.target.MaskedReadNow(r.reg, "chan_inc")
+}
+
+// Write value to the masked bits.
+func (r FPGATimingCsrCtrlChanInc) Write(value uint32) error {
+	_, err := r.target.MaskedWriteNow(r.reg, "chan_inc", value)
+	return err
+}
+
+// FPGATimingSctr is timing.sctr.
+type FPGATimingSctr struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGATimingSctr) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGATimingSctr) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGATimingSctr) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// Read nword words.
+func (r FPGATimingSctr) ReadBlock(nword uint) ([]uint32, error) {
+	return r.target.ReadNow(r.reg, nword)
+}
+
+// Write the words of data.
+func (r FPGATimingSctr) WriteBlock(data []uint32) error {
+	return r.target.WriteNow(r.reg, data)
+}
+
+// Bottom is bottom at 0x00000042.
+func (r FPGATimingSctr) Bottom() FPGATimingSctrBottom {
+	return FPGATimingSctrBottom{r.target, r.target.Regs["timing.sctr.bottom"]}
+}
+
+// Top is top at 0x00000043.
+func (r FPGATimingSctr) Top() FPGATimingSctrTop {
+	return FPGATimingSctrTop{r.target, r.target.Regs["timing.sctr.top"]}
+}
+
+// FPGATimingSctrBottom is timing.sctr.bottom.
+type FPGATimingSctrBottom struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGATimingSctrBottom) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGATimingSctrBottom) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGATimingSctrBottom) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
+
+// FPGATimingSctrTop is timing.sctr.top.
+type FPGATimingSctrTop struct {
+	target ipbus.Target
+	reg    ipbus.Register
+}
+
+// The register.
+func (r FPGATimingSctrTop) Register() ipbus.Register {
+	return r.reg
+}
+
+// Read the value.
+func (r FPGATimingSctrTop) Read() (uint32, error) {
+	data, err := r.target.ReadNow(r.reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Write value.
+func (r FPGATimingSctrTop) Write(value uint32) error {
+	return r.target.WriteNow(r.reg, []uint32{value})
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package solidfpga
+
+import (
+	"testing"
+
+	"github.com/go-daq/ipbus"
+)
+
+func TestGenerated(t *testing.T) {
+	target, lb, err := ipbus.NewLoopback("fpga", "../../testdata/8chanxml/addr_table/top.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fpga, err := NewFPGA(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl := fpga.CtrlReg().Ctrl()
+	if err := ctrl.Write(0x12340000); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctrl.SoftRst().Write(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctrl.Chan().Write(0xab); err != nil {
+		t.Fatal(err)
+	}
+	if val := lb.Read(ctrl.Register().Addr); val != 0x1234ab01 {
+		t.Errorf("ctrl_reg.ctrl is 0x%x, expected 0x1234ab01", val)
+	}
+	board, err := ctrl.BoardId().Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if board != 0x34 {
+		t.Errorf("board_id is 0x%x, expected 0x34", board)
+	}
+	lb.Push(fpga.Chan().Fifo().Register().Addr, 1, 2, 3)
+	data, err := fpga.Chan().Fifo().ReadBlock(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 3 || data[0] != 1 || data[2] != 3 {
+		t.Errorf("Read %v from chan.fifo, expected [1 2 3]", data)
+	}
+
+	other, _, err := ipbus.NewLoopback("other", "../../testdata/xml/dummy_address.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFPGA(other); err == nil {
+		t.Errorf("No error for target with a different address table.")
+	}
+}
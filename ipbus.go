@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package ipbus enables communication with FPGAs using the IPbus UDP protocol.
+// Package ipbus enables communication with FPGAs using the IPbus protocol over UDP or TCP.
 package ipbus
 
 import (
@@ -5,12 +5,17 @@
 package ipbus
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
+	"runtime"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 )
@@ -18,37 +23,52 @@ import (
 const failunwritten = false
 
 var dummy *dummyHardware
+var emulator *Emulator
 var dt = 60 * time.Second
 var log *os.File
 var target *Target
 var nodummy *bool
+var uhaldummy *bool
 var trenztarget *Target
 var trenz *bool
 var ipbusverbose *bool
 
 func TestMain(m *testing.M) {
 	ipbusverbose = flag.Bool("ipbusverbose", false, "Turn on verbosity of ipbus package")
-	nodummy = flag.Bool("nodummyhardware", true, "Skip tests requiring dummy hardware.")
+	nodummy = flag.Bool("nodummyhardware", false, "Skip tests requiring dummy hardware.")
+	uhaldummy = flag.Bool("uhaldummyhardware", false, "Test against the uHAL DummyHardwareUdp.exe instead of the Go emulator.")
 	trenz = flag.Bool("trenzhardware", false, "Enable tests against Trenz board.")
 	flag.Parse()
 	verbose = *ipbusverbose
-	verbose = *ipbusverbose
+	addr := "localhost:60001"
 	if !*nodummy {
-		startdummy()
-		defer dummy.Stop()
+		if *uhaldummy {
+			startdummy()
+			defer dummy.Stop()
+		} else {
+			startemulator()
+			addr = emulator.Addr().String()
+		}
 	}
-	starttarget()
-	if testing.Verbose() {
-		fmt.Printf("Target regs: %v\n", target.Regs)
+	// The shared target needs a device to answer its status request, tests
+	// using it are skipped without one.
+	if !*nodummy {
+		starttarget(addr)
+		if testing.Verbose() {
+			fmt.Printf("Target regs: %v\n", target.Regs)
+		}
 	}
 	if *trenz {
 		starttrenz()
 	}
 	code := m.Run()
-	if !*nodummy {
+	if dummy != nil {
 		dummy.Kill <- true
+		time.Sleep(time.Second)
+	}
+	if emulator != nil {
+		emulator.Close()
 	}
-	time.Sleep(time.Second)
 	os.Exit(code)
 }
 
@@ -77,7 +97,7 @@ func starttrenz() {
 			fmt.Printf("\t%v\n", trenztarget.Regs[regname])
 		}
 		if *ipbusverbose {
-			trenztarget.hw.nverbose = 1
+			trenztarget.hw.SetVerbose(1)
 		}
 	}
 
@@ -99,9 +119,19 @@ func startdummy() {
 	}
 }
 
-func starttarget() {
+func startemulator() {
+	if emulator == nil {
+		e, err := NewEmulator("localhost:0")
+		if err != nil {
+			panic(err)
+		}
+		emulator = e
+	}
+}
+
+func starttarget(addr string) {
 	if target == nil {
-		raddr, err := net.ResolveUDPAddr("udp4", "localhost:60001")
+		raddr, err := net.ResolveUDPAddr("udp4", addr)
 		if err != nil {
 			panic(err)
 		}
@@ -115,35 +145,39 @@ func starttarget() {
 		}
 		target = &t
 		if *ipbusverbose {
-			target.hw.nverbose = 1
+			target.hw.SetVerbose(1)
 		}
 	}
 }
 
 // Ensure that creating a new target times out when there's no target present.
-/*
 func TestTimeout(t *testing.T) {
-
-	conn, err := net.Dial("udp4", "locahost:60006")
+	// A socket that never answers.
+	nodevice, err := net.ListenPacket("udp4", "localhost:0")
 	if err != nil {
 		t.Fatal(err)
 	}
-	notarget, err := New("nodummy", "testdata/xml/dummy_address.xml", conn)
+	defer nodevice.Close()
+	conn, err := net.Dial("udp4", nodevice.LocalAddr().String())
 	if err != nil {
 		t.Fatal(err)
 	}
-	if failunwritten {
-		t.Errorf("Test function not yet implemented.")
+	start := time.Now()
+	_, err = New("nodummy", "testdata/xml/dummy_address.xml", conn, WithHandshakeTimeout(100*time.Millisecond))
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Creating target without device gave %v, expected ErrTimeout", err)
+	}
+	if dt := time.Since(start); dt > time.Second {
+		t.Errorf("Creating target without device took %v, expected 100 ms", dt)
 	}
 }
-*/
 
 // Test single word read and write.
 func TestSingleReadWrite(t *testing.T) {
-	if *nodummy {
+	if target == nil {
 		t.Skip()
 	}
-	testreg := Register{"REG", uint32(0x1), make([]string, 0), false, 1, make(map[string]msk)}
+	testreg := Register{"REG", uint32(0x1), make([]string, 0), ReadWrite, nil, false, 1, make(map[string]msk)}
 	/*
 		testreg, ok := target.Regs["REG"]
 		if !ok {
@@ -175,10 +209,10 @@ func TestSingleReadWrite(t *testing.T) {
 }
 
 func TestRMWbits(t *testing.T) {
-	if *nodummy {
+	if target == nil {
 		t.Skip()
 	}
-	testreg := Register{"REG", uint32(0x1), make([]string, 0), false, 1, make(map[string]msk)}
+	testreg := Register{"REG", uint32(0x1), make([]string, 0), ReadWrite, nil, false, 1, make(map[string]msk)}
 	/*
 		testreg, ok := target.Regs["REG"]
 		if !ok {
@@ -223,10 +257,10 @@ func TestRMWbits(t *testing.T) {
 }
 
 func TestRMWsum(t *testing.T) {
-	if *nodummy {
+	if target == nil {
 		t.Skip()
 	}
-	testreg := Register{"REG", uint32(0x1), make([]string, 0), false, 1, make(map[string]msk)}
+	testreg := Register{"REG", uint32(0x1), make([]string, 0), ReadWrite, nil, false, 1, make(map[string]msk)}
 	/*
 		testreg, ok := target.Regs["REG"]
 		if !ok {
@@ -271,11 +305,11 @@ func TestRMWsum(t *testing.T) {
 
 // Test block read and block write.
 func TestBlockReadWriteInc(t *testing.T) {
-	if *nodummy {
+	if target == nil {
 		t.Skip()
 	}
 
-	testreg := Register{"MEM", uint32(0x100000), make([]string, 0), false, 268435456, make(map[string]msk)}
+	testreg := Register{"MEM", uint32(0x100000), make([]string, 0), ReadWrite, nil, false, 268435456, make(map[string]msk)}
 	//testreg, ok := target.Regs["MEM"]
 	//if !ok {
 	//t.Fatalf("Couldn't find test register 'MEM' in dummy device description.")
@@ -337,11 +371,11 @@ func TestBlockReadWriteInc(t *testing.T) {
 // Test block read and block write of non-incrementing FIFO.
 // The dummy hardware FIFO just reads back the last value it received.
 func TestBlockReadWriteNonInc(t *testing.T) {
-	if *nodummy {
+	if target == nil {
 		t.Skip()
 	}
 
-	testreg := Register{"FIFO", uint32(0x0100), make([]string, 0), true, 268435456, make(map[string]msk)}
+	testreg := Register{"FIFO", uint32(0x0100), make([]string, 0), ReadWrite, nil, true, 268435456, make(map[string]msk)}
 	nvals := 350
 	outdata := make([]uint32, nvals)
 	indata := make([]uint32, 0, nvals)
@@ -388,61 +422,373 @@ func TestBlockReadWriteNonInc(t *testing.T) {
 	}
 }
 
-// Test that the library returns correct errors when going against target's permissions.
-func TestPermissions(t *testing.T) {
+// Test block write and read back over an ipbustcp-2.0 connection.
+func TestTCPReadWrite(t *testing.T) {
 	if *nodummy {
 		t.Skip()
 	}
-	/*
-		cm, err := NewCM("testdata/xml/dummy_connections.xml")
+	emu, err := NewTCPEmulator("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emu.Close()
+	conn, err := net.Dial("tcp", emu.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcptarget, err := New("tcpdummy", "testdata/xml/dummy_address.xml", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testreg := Register{"MEM", uint32(0x100000), make([]string, 0), ReadWrite, nil, false, 262144, make(map[string]msk)}
+	nvals := 1000
+	outdata := make([]uint32, nvals)
+	for i := 0; i < nvals; i++ {
+		outdata[i] = uint32(i) * 3
+	}
+	if err := tcptarget.WriteNow(testreg, outdata); err != nil {
+		t.Fatal(err)
+	}
+	indata, err := tcptarget.ReadNow(testreg, uint(nvals))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indata) != nvals {
+		t.Fatalf("Expected %d values, received %d", nvals, len(indata))
+	}
+	for i := 0; i < nvals; i++ {
+		if indata[i] != outdata[i] {
+			t.Fatalf("indata[%d] = 0x%x, expected 0x%x", i, indata[i], outdata[i])
+		}
+	}
+}
+
+// Test that the connection manager picks TCP for ipbustcp-2.0 URIs.
+func TestConnectionManagerTCP(t *testing.T) {
+	if *nodummy {
+		t.Skip()
+	}
+	emu, err := NewTCPEmulator("localhost:60002")
+	if err != nil {
+		t.Skipf("Cannot listen on port 60002: %v", err)
+	}
+	defer emu.Close()
+	cm, err := NewCM("testdata/xml/dummy_connections.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcptarget, err := cm.Target("dummy.tcp2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tcptarget.hw.reliable {
+		t.Errorf("Target for ipbustcp-2.0 URI is not using a reliable transport.")
+	}
+	testreg := Register{"REG", uint32(0x1), make([]string, 0), ReadWrite, nil, false, 1, make(map[string]msk)}
+	if err := tcptarget.WriteNow(testreg, []uint32{0xcafe}); err != nil {
+		t.Fatal(err)
+	}
+	if val := emu.Read(0x1); val != 0xcafe {
+		t.Errorf("Emulator has 0x%x at 0x1, expected 0xcafe", val)
+	}
+	if _, err := cm.Target("dummy.tcp"); err == nil {
+		t.Errorf("No error for unsupported ipbustcp-1.3 protocol.")
+	}
+}
+
+// Test several targets sharing one ControlHub connection.
+func TestControlHub(t *testing.T) {
+	if *nodummy {
+		t.Skip()
+	}
+	hubemu, err := NewControlHubEmulator("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hubemu.Close()
+	hubemu.Timeout = 100 * time.Millisecond
+	hub, err := DialControlHub(hubemu.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+	emus := make([]*Emulator, 2)
+	targets := make([]Target, 2)
+	for i := range emus {
+		emu, err := NewEmulator("localhost:0")
 		if err != nil {
 			t.Fatal(err)
 		}
-		target, err := cm.Target("dummy.udp2")
+		defer emu.Close()
+		emus[i] = emu
+		tr, err := hub.Transport(emu.Addr().String())
 		if err != nil {
 			t.Fatal(err)
 		}
-		writeonlyreg, ok := target.Regs["REG_WRITE_ONLY"]
-		if !ok {
-			t.Fatalf("Failed to find `REG_WRITE_ONLY` register.")
+		targets[i], err = NewWithTransport(fmt.Sprintf("hub%d", i), "testdata/xml/dummy_address.xml", tr)
+		if err != nil {
+			t.Fatal(err)
 		}
-		readonlyreg, ok := target.Regs["REG_READ_ONLY"]
-		if !ok {
-			t.Fatalf("Failed to find `REG_READ_ONLY` register.")
+	}
+	testreg := Register{"REG", uint32(0x1), make([]string, 0), ReadWrite, nil, false, 1, make(map[string]msk)}
+	for i, tg := range targets {
+		if err := tg.WriteNow(testreg, []uint32{uint32(0x100 + i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, emu := range emus {
+		if val := emu.Read(0x1); val != uint32(0x100+i) {
+			t.Errorf("Emulator %d has 0x%x at 0x1, expected 0x%x", i, val, 0x100+i)
 		}
-		t.Logf("Read-only: %v, write-only: %v\n", readonlyreg, writeonlyreg)
+	}
+	mem := Register{"MEM", uint32(0x100000), make([]string, 0), ReadWrite, nil, false, 262144, make(map[string]msk)}
+	nvals := 1000
+	outdata := make([]uint32, nvals)
+	for i := 0; i < nvals; i++ {
+		outdata[i] = uint32(i) + 7
+	}
+	if err := targets[1].WriteNow(mem, outdata); err != nil {
+		t.Fatal(err)
+	}
+	indata, err := targets[1].ReadNow(mem, uint(nvals))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < nvals; i++ {
+		if indata[i] != outdata[i] {
+			t.Fatalf("indata[%d] = 0x%x, expected 0x%x", i, indata[i], outdata[i])
+		}
+	}
 
-		t.Log("Tring to read from a write-only regiser.")
-	*/
-	/*
-		respchan := target.Read(writeonlyreg, 1)
-		target.Dispatch()
-		resp := <-respchan
-		if resp.Err == nil || resp.Code != BusReadError {
-			t.Errorf("Expected permission fail when reading write-only register. Err = %v, code = %v.\n", resp.Err, resp.Code)
+	// A device that never answers is reported by the hub.
+	absent, err := NewEmulator("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	absentaddr := absent.Addr().String()
+	absent.Close()
+	tr, err := hub.Transport(absentaddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notarget, err := NewWithTransport("absent", "testdata/xml/dummy_address.xml", tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notarget.WriteNow(testreg, []uint32{1}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Writing to absent device behind ControlHub gave %v, expected ErrTimeout.", err)
+	} else {
+		t.Logf("Absent device: %v", err)
+	}
+	if _, _, err := splithubaddr("localhost:10203"); err == nil {
+		t.Errorf("No error for ControlHub address without target.")
+	}
+}
+
+// Test a target talking to an in-process emulator through a memory transport.
+func TestMemoryTransport(t *testing.T) {
+	emu := newemulator()
+	tr := NewMemoryTransport(func(request []byte) []byte {
+		return emu.handle(request, true)
+	})
+	memtarget, err := NewWithTransport("memdummy", "testdata/xml/dummy_address.xml", tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testreg := Register{"REG", uint32(0x1), make([]string, 0), ReadWrite, nil, false, 1, make(map[string]msk)}
+	if err := memtarget.WriteNow(testreg, []uint32{0xbeef}); err != nil {
+		t.Fatal(err)
+	}
+	if val := emu.Read(0x1); val != 0xbeef {
+		t.Errorf("Emulator has 0x%x at 0x1, expected 0xbeef", val)
+	}
+	emu.Write(0x1, 0xf00d)
+	indata, err := memtarget.ReadNow(testreg, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indata) != 1 || indata[0] != 0xf00d {
+		t.Errorf("Read %v from memory transport, expected [0xf00d]", indata)
+	}
+}
+
+// Test the loopback target and the hooks into its register model.
+func TestLoopback(t *testing.T) {
+	lbtarget, lb, err := NewLoopback("loopback", "testdata/xml/dummy_address.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := []uint32{}
+	lb.OnWrite(func(addr, val uint32) {
+		if addr == 0x1 {
+			written = append(written, val)
 		}
-	*/
-	t.Log("Trying to write to a read-only register.")
-	/*
-		respchan = target.Write(readonlyreg, []uint32{0})
-		target.Dispatch()
-		resp = <-respchan
-		if resp.Err == nil || resp.Code != BusWriteError {
-			t.Errorf("Expected permission fail when writing read-only register. Err = %v, code = %v.\n", resp.Err, resp.Code)
+	})
+	testreg := Register{"REG", uint32(0x1), make([]string, 0), ReadWrite, nil, false, 1, make(map[string]msk)}
+	if err := lbtarget.WriteNow(testreg, []uint32{0x12345678}); err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || written[0] != 0x12345678 {
+		t.Errorf("OnWrite saw %x, expected [12345678]", written)
+	}
+	rc := lbtarget.RMWbits(testreg, 0xffff0000, 0xabcd)
+	lbtarget.Dispatch()
+	if r := <-rc; r.Err != nil || r.Data[0] != 0x12345678 {
+		t.Errorf("RMWbits replied %v, expected previous value 0x12345678", r)
+	}
+	if val := lb.Read(0x1); val != 0x1234abcd {
+		t.Errorf("Loopback has 0x%x at 0x1 after RMWbits, expected 0x1234abcd", val)
+	}
+	lb.OnRead(func(addr, val uint32) uint32 {
+		return val + 1
+	})
+	indata, err := lbtarget.ReadNow(testreg, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indata[0] != 0x1234abce {
+		t.Errorf("Read 0x%x with OnRead hook, expected 0x1234abce", indata[0])
+	}
+	lb.OnRead(nil)
+
+	fifo := Register{"FIFO", uint32(0x100), make([]string, 0), ReadWrite, nil, true, 1, make(map[string]msk)}
+	lb.Push(0x100, 1, 2, 3)
+	indata, err = lbtarget.ReadNow(fifo, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint32{1, 2, 3, 0}
+	for i := range expected {
+		if indata[i] != expected[i] {
+			t.Errorf("FIFO read %v, expected %v", indata, expected)
+			break
 		}
-	*/
+	}
 
-	if failunwritten {
-		t.Errorf("Test function not yet implemented.")
+	lb.Fail(0x1, BusReadError)
+	rc = lbtarget.Read(testreg, 1)
+	lbtarget.Dispatch()
+	if r := <-rc; r.Err == nil || r.Code != BusReadError {
+		t.Errorf("Read of failing address replied %v, expected code %v", r, BusReadError)
+	}
+	lb.Fail(0x1, Success)
+	if _, err := lbtarget.ReadNow(testreg, 1); err != nil {
+		t.Errorf("Read failed after clearing info code: %v", err)
+	}
+}
+
+// Test that the library returns correct errors when going against target's permissions.
+func TestPermissions(t *testing.T) {
+	if target == nil {
+		t.Skip()
+	}
+	writeonlyreg, ok := target.Regs["REG_WRITE_ONLY"]
+	if !ok {
+		t.Fatalf("Failed to find `REG_WRITE_ONLY` register.")
+	}
+	readonlyreg, ok := target.Regs["REG_READ_ONLY"]
+	if !ok {
+		t.Fatalf("Failed to find `REG_READ_ONLY` register.")
+	}
+	t.Logf("Read-only: %v, write-only: %v\n", readonlyreg, writeonlyreg)
+	if readonlyreg.Permission != ReadOnly || writeonlyreg.Permission != WriteOnly {
+		t.Fatalf("Parsed permissions %v and %v, expected r and w.", readonlyreg.Permission, writeonlyreg.Permission)
+	}
+
+	t.Log("Tring to read from a write-only regiser.")
+	respchan := target.Read(writeonlyreg, 1)
+	target.Dispatch()
+	resp := <-respchan
+	if resp.Err == nil || resp.Code != BusReadError {
+		t.Errorf("Expected permission fail when reading write-only register. Err = %v, code = %v.\n", resp.Err, resp.Code)
+	}
+	if _, ok := resp.Err.(*PermissionError); !ok {
+		t.Errorf("Expected *PermissionError reading write-only register, got %T.", resp.Err)
+	}
+	t.Log("Trying to write to a read-only register.")
+	respchan = target.Write(readonlyreg, []uint32{0})
+	target.Dispatch()
+	resp = <-respchan
+	if resp.Err == nil || resp.Code != BusWriteError {
+		t.Errorf("Expected permission fail when writing read-only register. Err = %v, code = %v.\n", resp.Err, resp.Code)
+	}
+	if r := <-target.RMWbits(readonlyreg, 0, 1); r.Err == nil {
+		t.Errorf("Expected permission fail for RMWbits on read-only register.")
+	}
+	if r := <-target.RMWsum(readonlyreg, 1); r.Err == nil {
+		t.Errorf("Expected permission fail for RMWsum on read-only register.")
+	}
+	if _, err := target.ReadNow(writeonlyreg, 1); err == nil {
+		t.Errorf("Expected permission fail for ReadNow on write-only register.")
+	}
+	if err := target.WriteNow(readonlyreg, []uint32{1}); err == nil {
+		t.Errorf("Expected permission fail for WriteNow on read-only register.")
+	}
+}
+
+// Test that block transfers past the end of a memory are rejected or clamped.
+func TestBlockBounds(t *testing.T) {
+	lbtarget, lb, err := NewLoopback("bounds", "testdata/xml/dummy_address.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, ok := lbtarget.Regs["SMALL_MEM"]
+	if !ok {
+		t.Fatalf("Failed to find `SMALL_MEM` register.")
+	}
+	if mem.Size() != 256 {
+		t.Fatalf("SMALL_MEM has size %d, expected 256.", mem.Size())
+	}
+	if _, err := lbtarget.ReadNow(mem, 256); err != nil {
+		t.Errorf("Failed to read whole of SMALL_MEM: %v", err)
+	}
+	if _, err := lbtarget.ReadNow(mem, 257); err == nil {
+		t.Errorf("No error reading past the end of SMALL_MEM.")
+	} else if _, ok := err.(*SizeError); !ok {
+		t.Errorf("Expected *SizeError reading past end of SMALL_MEM, got %T.", err)
+	}
+	outdata := make([]uint32, 300)
+	for i := range outdata {
+		outdata[i] = uint32(i) + 1
+	}
+	if err := lbtarget.WriteNow(mem, outdata); err == nil {
+		t.Errorf("No error writing past the end of SMALL_MEM.")
+	}
+	if val := lb.Read(mem.Addr); val != 0 {
+		t.Errorf("Rejected write changed SMALL_MEM[0] to 0x%x.", val)
+	}
+	lbtarget.ClampTransfers = true
+	if err := lbtarget.WriteNow(mem, outdata); err != nil {
+		t.Fatal(err)
+	}
+	if val := lb.Read(mem.Addr + 255); val != 256 {
+		t.Errorf("SMALL_MEM[255] = %d after clamped write, expected 256.", val)
+	}
+	if val := lb.Read(mem.Addr + 256); val != 0 {
+		t.Errorf("Clamped write overran SMALL_MEM, wrote %d after the end.", val)
+	}
+	indata, err := lbtarget.ReadNow(mem, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indata) != 256 {
+		t.Errorf("Clamped read returned %d words, expected 256.", len(indata))
+	}
+	fifo, ok := lbtarget.Regs["FIFO"]
+	if !ok {
+		t.Fatalf("Failed to find `FIFO` register.")
+	}
+	if !fifo.noninc {
+		t.Errorf("FIFO is not non-incrementing.")
 	}
 }
 
 // Bench mark single word read.
 func BenchmarkSingleRead(b *testing.B) {
-	if *nodummy {
+	if target == nil {
 		b.Skip()
 	}
-	testreg := Register{"REG", uint32(0x1), make([]string, 0), false, 1, make(map[string]msk)}
+	testreg := Register{"REG", uint32(0x1), make([]string, 0), ReadWrite, nil, false, 1, make(map[string]msk)}
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
 		respchan := target.Read(testreg, 1)
@@ -639,10 +985,10 @@ func BenchmarkMultiReadBTrenz(b *testing.B) {
 
 // Bench mark single word write.
 func BenchmarkSingleWrite(b *testing.B) {
-	if *nodummy {
+	if target == nil {
 		b.Skip()
 	}
-	testreg := Register{"REG", uint32(0x1), make([]string, 0), false, 1, make(map[string]msk)}
+	testreg := Register{"REG", uint32(0x1), make([]string, 0), ReadWrite, nil, false, 1, make(map[string]msk)}
 	outdata := []uint32{0xdeadbeef}
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
@@ -657,11 +1003,11 @@ func BenchmarkSingleWrite(b *testing.B) {
 
 // Bench mark multi-packet block reads.
 func BenchmarkBlockRead(b *testing.B) {
-	if *nodummy {
+	if target == nil {
 		b.Skip()
 	}
 
-	testreg := Register{"MEM", uint32(0x100000), make([]string, 0), false, 262144, make(map[string]msk)}
+	testreg := Register{"MEM", uint32(0x100000), make([]string, 0), ReadWrite, nil, false, 262144, make(map[string]msk)}
 	nword := 1000
 	b.Logf("Writing %d bytes.", nword*4*b.N)
 	b.ResetTimer()
@@ -680,11 +1026,11 @@ func BenchmarkBlockRead(b *testing.B) {
 
 // Bench mark multi-packet block writes.
 func BenchmarkBlockWrite(b *testing.B) {
-	if *nodummy {
+	if target == nil {
 		b.Skip()
 	}
 
-	testreg := Register{"MEM", uint32(0x100000), make([]string, 0), false, 262144, make(map[string]msk)}
+	testreg := Register{"MEM", uint32(0x100000), make([]string, 0), ReadWrite, nil, false, 262144, make(map[string]msk)}
 	nword := 1000
 	outdata := make([]uint32, nword)
 	for i := 0; i < nword; i++ {
@@ -702,3 +1048,585 @@ func BenchmarkBlockWrite(b *testing.B) {
 		}
 	}
 }
+
+func TestStructFields(t *testing.T) {
+	lbtarget, lb, err := NewLoopback("loopback", "testdata/8chanxml/addr_table/top.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lbtarget.Close()
+	type chanctrl struct {
+		Sync    bool   `ipbus:"en_sync"`
+		Comp    bool   `ipbus:"en_comp"`
+		Pattern uint16 `ipbus:"patt"`
+		Note    string
+	}
+	ctrl := lbtarget.Regs["chan.csr.ctrl"]
+	lb.Write(ctrl.Addr, 0x8000ff00)
+	writes := 0
+	lb.OnWrite(func(addr, val uint32) { writes++ })
+	prev, err := lbtarget.WriteStructNow(ctrl, &chanctrl{Sync: true, Pattern: 0x1234})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev != 0x8000ff00 {
+		t.Errorf("WriteStructNow returned previous value 0x%x, expected 0x8000ff00", prev)
+	}
+	if val := lb.Read(ctrl.Addr); val != 0x9234ff01 {
+		t.Errorf("chan.csr.ctrl is 0x%x after WriteStructNow, expected 0x9234ff01", val)
+	}
+	if writes != 1 {
+		t.Errorf("WriteStructNow took %d writes, expected 1", writes)
+	}
+	got := chanctrl{}
+	lb.Write(ctrl.Addr, 0x00560002)
+	if err := lbtarget.ReadStructNow(ctrl, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (chanctrl{Comp: true, Pattern: 0x56}) {
+		t.Errorf("ReadStructNow decoded %+v", got)
+	}
+
+	if _, _, err := ctrl.Encode(&chanctrl{Pattern: 0x4000}); err == nil {
+		t.Errorf("No error encoding a value wider than its mask.")
+	}
+	type badmask struct {
+		X uint32 `ipbus:"missing"`
+	}
+	if err := ctrl.Decode(0, &badmask{}); err == nil {
+		t.Errorf("No error decoding into a field tagged with a missing mask.")
+	}
+	type narrow struct {
+		Pattern uint8 `ipbus:"patt"`
+	}
+	if err := ctrl.Decode(0x3fff0000, &narrow{}); err == nil {
+		t.Errorf("No error decoding a mask into a field too small for it.")
+	}
+	if err := ctrl.Decode(0, chanctrl{}); err == nil {
+		t.Errorf("No error decoding into a struct value rather than a pointer.")
+	}
+	type unexported struct {
+		patt uint8 `ipbus:"patt"`
+	}
+	if err := ctrl.Decode(0, &unexported{}); err == nil {
+		t.Errorf("No error decoding into an unexported field.")
+	}
+	if _, _, err := ctrl.Encode(&unexported{}); err == nil {
+		t.Errorf("No error encoding an unexported field.")
+	}
+}
+
+func TestContext(t *testing.T) {
+	// A device that never answers.
+	silent, err := NewWithTransport("silent", "testdata/xml/dummy_address.xml",
+		NewMemoryTransport(func([]byte) []byte { return nil }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	reg := silent.Regs["REG"]
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := silent.ReadCtx(ctx, reg, 1); err != context.DeadlineExceeded {
+		t.Errorf("ReadCtx returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if err := silent.WriteCtx(ctx, reg, []uint32{1}); err != context.DeadlineExceeded {
+		t.Errorf("WriteCtx with expired context returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	rc := silent.Read(silent.Regs["MEM"], 1000)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- silent.DispatchCtx(ctx)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	nrep := 0
+	for r := range rc {
+		nrep++
+		if r.Err != context.Canceled {
+			t.Errorf("Abandoned transaction replied %v, expected %v", r.Err, context.Canceled)
+		}
+	}
+	if nrep < 3 {
+		t.Errorf("Received %d replies to a read split over several transactions", nrep)
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("DispatchCtx returned %v, expected %v", err, context.Canceled)
+	}
+
+	lbtarget, _, err := NewLoopback("loopback", "testdata/xml/dummy_address.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lbtarget.WriteCtx(context.Background(), reg, []uint32{0xcafe}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := lbtarget.ReadCtx(context.Background(), reg, 1)
+	if err != nil || len(data) != 1 || data[0] != 0xcafe {
+		t.Errorf("ReadCtx returned %x, %v, expected [cafe]", data, err)
+	}
+	rc = lbtarget.Write(reg, []uint32{0xbeef})
+	go func() {
+		errs <- lbtarget.DispatchCtx(context.Background())
+	}()
+	if r := <-rc; r.Err != nil {
+		t.Error(r.Err)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("DispatchCtx returned %v", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	lb := newloopback()
+	npacket := 0
+	lbtarget, err := NewWithTransport("batch", "testdata/xml/dummy_address.xml",
+		NewMemoryTransport(func(request []byte) []byte {
+			npacket++
+			return lb.handle(request)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lbtarget.Close()
+	lb.Write(0x1, 0x1234)
+	lb.Push(0x100, 7, 8, 9)
+	b := lbtarget.NewBatch()
+	reg := b.Read(lbtarget.Regs["REG"])
+	fifo := b.ReadBlock(lbtarget.Regs["FIFO"], 3)
+	written := b.Write(lbtarget.Regs["REG_UPPER_MASK"], 0xabcd0000)
+	upper := b.ReadMask(lbtarget.Regs["REG_UPPER_MASK"], "REG_UPPER_MASK")
+	prev := b.RMWsum(lbtarget.Regs["REG"], 1)
+	if reg.Valid() || b.Len() != 5 {
+		t.Errorf("Batch has %d transactions, handle valid before dispatch: %t", b.Len(), reg.Valid())
+	}
+	if err := b.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if npacket != 1 {
+		t.Errorf("Batch sent in %d packets, expected 1", npacket)
+	}
+	if !reg.Valid() || reg.Value() != 0x1234 {
+		t.Errorf("Read 0x%x (valid %t), expected 0x1234", reg.Value(), reg.Valid())
+	}
+	if fmt.Sprint(fifo.Value()) != "[7 8 9]" {
+		t.Errorf("Read %v from FIFO, expected [7 8 9]", fifo.Value())
+	}
+	if !written.Valid() || upper.Value() != 0xabcd || prev.Value() != 0x1234 {
+		t.Errorf("Write valid %t, read mask 0x%x, RMWsum previous value 0x%x", written.Valid(), upper.Value(), prev.Value())
+	}
+	if lb.Read(0x1) != 0x1235 {
+		t.Errorf("REG is 0x%x after RMWsum, expected 0x1235", lb.Read(0x1))
+	}
+
+	lb.Fail(0x1, BusReadError)
+	failed := b.Read(lbtarget.Regs["REG"])
+	badmask := b.MaskedWrite(lbtarget.Regs["REG"], "missing", 1)
+	if err := b.Dispatch(context.Background()); err == nil {
+		t.Errorf("No error from batch with a failed transaction.")
+	}
+	if failed.Valid() || failed.Err() == nil || badmask.Err() == nil {
+		t.Errorf("Failed read valid %t, error %v, bad mask error %v", failed.Valid(), failed.Err(), badmask.Err())
+	}
+}
+
+func TestAutoDispatch(t *testing.T) {
+	lbtarget, lb, err := NewLoopback("auto", "testdata/xml/dummy_address.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lbtarget.Close()
+	lb.Write(0x1, 0x42)
+	lbtarget.AutoDispatch = true
+	lbtarget.TimeoutPeriod = 20 * time.Millisecond
+	start := time.Now()
+	rc := lbtarget.Read(lbtarget.Regs["REG"], 1)
+	select {
+	case r := <-rc:
+		if r.Err != nil || r.Data[0] != 0x42 {
+			t.Errorf("Read %v, expected [0x42]", r)
+		}
+		if dt := time.Since(start); dt < lbtarget.TimeoutPeriod {
+			t.Errorf("Partial packet sent after %v, before the timeout period", dt)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Partial packet not sent without Dispatch.")
+	}
+
+	// Full packets go straight away, the rest waits for the period.
+	lbtarget.TimeoutPeriod = time.Hour
+	rc = lbtarget.Read(lbtarget.Regs["MEM"], 1000)
+	nword := 0
+	select {
+	case r := <-rc:
+		if r.Err != nil {
+			t.Error(r.Err)
+		}
+		nword += len(r.Data)
+	case <-time.After(time.Second):
+		t.Fatalf("Full packet not sent without Dispatch.")
+	}
+	go lbtarget.Dispatch()
+	for r := range rc {
+		nword += len(r.Data)
+	}
+	if nword != 1000 {
+		t.Errorf("Read %d words, expected 1000", nword)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	lbtarget, _, err := NewLoopback("dispatch", "testdata/xml/dummy_address.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lbtarget.Close()
+	mem := lbtarget.Regs["MEM"]
+	data := make([]uint32, 1000)
+	for i := range data {
+		data[i] = uint32(i)
+	}
+	wc := lbtarget.Write(mem, data)
+	rc := lbtarget.Read(mem, 1000)
+	empty := lbtarget.Read(mem, 0)
+	// All replies must be waiting in their channels once Dispatch returns.
+	lbtarget.Dispatch()
+	ready := func(rc chan Response) []Response {
+		reps := []Response{}
+		for {
+			select {
+			case r, ok := <-rc:
+				if !ok {
+					return reps
+				}
+				if r.Err != nil {
+					t.Fatal(r.Err)
+				}
+				reps = append(reps, r)
+			default:
+				t.Fatalf("Reply not ready after Dispatch returned.")
+			}
+		}
+	}
+	if reps := ready(wc); len(reps) == 0 {
+		t.Errorf("No replies to write.")
+	}
+	readback := []uint32{}
+	for _, r := range ready(rc) {
+		readback = append(readback, r.Data...)
+	}
+	if len(readback) != len(data) {
+		t.Fatalf("Read back %d words, expected %d", len(readback), len(data))
+	}
+	for i := range data {
+		if readback[i] != data[i] {
+			t.Fatalf("Read back 0x%x at %d, expected 0x%x", readback[i], i, data[i])
+		}
+	}
+	if reps := ready(empty); len(reps) != 0 {
+		t.Errorf("Got %d replies to an empty read.", len(reps))
+	}
+
+	// Flush sends without waiting, so the replies can be read as they arrive.
+	rc = lbtarget.Read(mem, 1000)
+	lbtarget.Flush()
+	nword := 0
+	for r := range rc {
+		nword += len(r.Data)
+	}
+	if nword != 1000 {
+		t.Errorf("Read %d words after Flush, expected 1000", nword)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	lbtarget, lb, err := NewLoopback("errors", "testdata/xml/dummy_address.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lbtarget.Close()
+	lb.Fail(0x1, BusReadTimeout)
+	_, err = lbtarget.ReadNow(lbtarget.Regs["REG"], 1)
+	terr := &TransactionError{}
+	if !errors.As(err, &terr) {
+		t.Fatalf("ReadNow gave %v, expected a *TransactionError", err)
+	}
+	if terr.Code != BusReadTimeout || terr.Register != "REG" || terr.Addr != 0x1 || terr.PacketID == 0 {
+		t.Errorf("Got %+v, expected bus read timeout of REG at 0x1", terr)
+	}
+	if errors.Is(err, ErrTimeout) {
+		t.Errorf("Bus timeout %v reported as a lost reply.", err)
+	}
+	t.Logf("Transaction error: %v", err)
+}
+
+func TestBadReplies(t *testing.T) {
+	lb := newloopback()
+	var mangle func(reply []byte) []byte
+	mu := sync.Mutex{}
+	tr := NewMemoryTransport(func(request []byte) []byte {
+		reply := lb.handle(request)
+		mu.Lock()
+		defer mu.Unlock()
+		if mangle != nil {
+			reply = mangle(reply)
+			mangle = nil
+		}
+		return reply
+	})
+	lbtarget, err := NewWithTransport("bad", "testdata/xml/dummy_address.xml", tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := lbtarget.Regs["REG"]
+	setmangle := func(m func([]byte) []byte) {
+		mu.Lock()
+		mangle = m
+		mu.Unlock()
+	}
+	expecterr := func(what string) {
+		select {
+		case err := <-lbtarget.Errors():
+			t.Logf("%s: %v", what, err)
+		case <-time.After(time.Second):
+			t.Errorf("No error reported for %s.", what)
+		}
+	}
+
+	setmangle(func(reply []byte) []byte {
+		th, _ := newTransactionHeader(reply[4:], defaultorder)
+		th.id = 5
+		th.encode(reply[4:], defaultorder)
+		return reply
+	})
+	if _, err := lbtarget.ReadNow(reg, 1); err == nil {
+		t.Errorf("No error for reply with invalid transaction ID.")
+	}
+	expecterr("invalid transaction ID")
+
+	setmangle(func(reply []byte) []byte {
+		return reply[:len(reply)-4]
+	})
+	if _, err := lbtarget.ReadNow(reg, 1); err == nil {
+		t.Errorf("No error for truncated reply.")
+	}
+	expecterr("truncated reply")
+
+	// The target still works.
+	lb.Write(0x1, 0x42)
+	if data, err := lbtarget.ReadNow(reg, 1); err != nil || data[0] != 0x42 {
+		t.Errorf("Read %v, %v after bad replies, expected [0x42]", data, err)
+	}
+
+	setmangle(func(reply []byte) []byte {
+		ph, _ := newPacketHeader(reply)
+		ph.pid += 100
+		ph.encode(reply)
+		return reply
+	})
+	// Over a reliable transport the request is never answered.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := lbtarget.ReadCtx(ctx, reg, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Reply with unknown ID gave %v, expected no reply.", err)
+	}
+	expecterr("unknown reply ID")
+
+	// Once the connection is closed every transaction fails.
+	tr.Close()
+	expecterr("closed connection")
+	if _, err := lbtarget.ReadNow(reg, 1); !errors.Is(err, io.EOF) {
+		t.Errorf("Read from closed connection gave %v, expected io.EOF", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	ngo := runtime.NumGoroutine()
+	silent, err := NewWithTransport("closed", "testdata/xml/dummy_address.xml",
+		NewMemoryTransport(func(request []byte) []byte { return nil }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := silent.Regs["REG"]
+	inflight := silent.Read(reg, 1)
+	silent.Flush()
+	queued := silent.Read(reg, 1)
+	if err := silent.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, rc := range []chan Response{inflight, queued} {
+		n := 0
+		for r := range rc {
+			if !errors.Is(r.Err, ErrTargetStopped) {
+				t.Errorf("Got %v from closed target, expected ErrTargetStopped", r.Err)
+			}
+			n++
+		}
+		if n != 1 {
+			t.Errorf("Got %d replies from closed target, expected 1", n)
+		}
+	}
+	if _, err := silent.ReadNow(reg, 1); !errors.Is(err, ErrTargetStopped) {
+		t.Errorf("Read after Close gave %v, expected ErrTargetStopped", err)
+	}
+	silent.Dispatch()
+	if err := silent.Close(); err != nil {
+		t.Errorf("Second Close: %v", err)
+	}
+	for i := 0; i < 100 && runtime.NumGoroutine() > ngo; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > ngo {
+		t.Errorf("%d goroutines after Close, %d before the target was created", n, ngo)
+	}
+}
+
+// Wait for a ReconnectEvent on the error stream of tg caused by cause.
+func waitreconnect(t *testing.T, tg Target, cause error) {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case err := <-tg.Errors():
+			ev := &ReconnectEvent{}
+			if errors.As(err, &ev) {
+				if !errors.Is(ev, cause) {
+					t.Errorf("Reconnected after %v, expected %v", ev.Cause, cause)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("No reconnect event.")
+		}
+	}
+}
+
+func TestDeviceReset(t *testing.T) {
+	emu, err := NewEmulator("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emu.Close()
+	conn, err := net.Dial("udp4", emu.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg, err := New("reset", "testdata/xml/dummy_address.xml", conn,
+		WithRetryPolicy(RetryPolicy{MaxResends: 3, Timeout: 50 * time.Millisecond, Backoff: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.Close()
+	reg := tg.Regs["REG"]
+	if err := tg.WriteNow(reg, []uint32{5}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The packets in flight when the device is reset fail, the queued ones
+	// are sent afterwards.
+	emu.Reset()
+	rc := tg.Read(tg.Regs["MEM"], 3000)
+	tg.Dispatch()
+	nreset, nok := 0, 0
+	for r := range rc {
+		switch {
+		case r.Err == nil:
+			nok++
+		case errors.Is(r.Err, ErrDeviceReset):
+			nreset++
+		default:
+			t.Errorf("Unexpected error: %v", r.Err)
+		}
+	}
+	if nreset == 0 || nok == 0 {
+		t.Errorf("%d transactions failed with ErrDeviceReset, %d succeeded, expected some of each", nreset, nok)
+	}
+	waitreconnect(t, tg, ErrDeviceReset)
+	if data, err := tg.ReadNow(reg, 1); err != nil || data[0] != 5 {
+		t.Errorf("Read %v, %v after reset, expected [5]", data, err)
+	}
+}
+
+func TestReconnectTCP(t *testing.T) {
+	emu, err := NewTCPEmulator("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emu.Close()
+	conn, err := net.Dial("tcp", emu.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg, err := New("reconnect", "testdata/xml/dummy_address.xml", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.Close()
+	reg := tg.Regs["REG"]
+	if err := tg.WriteNow(reg, []uint32{7}); err != nil {
+		t.Fatal(err)
+	}
+	emu.Reset()
+	waitreconnect(t, tg, io.EOF)
+	if data, err := tg.ReadNow(reg, 1); err != nil || data[0] != 7 {
+		t.Errorf("Read %v, %v after reconnecting, expected [7]", data, err)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	emu, err := NewEmulator("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emu.Close()
+	conn, err := net.Dial("udp4", emu.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg, err := New("retry", "testdata/xml/dummy_address.xml", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.Close()
+	tg.SetRetryPolicy(RetryPolicy{MaxResends: 2, Timeout: 50 * time.Millisecond, Backoff: 2})
+	reg := tg.Regs["REG"]
+	if err := tg.WriteNow(reg, []uint32{9}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A lost request is sent again, a lost reply is resent by the device.
+	emu.DropRequests(1)
+	if data, err := tg.ReadNow(reg, 1); err != nil || data[0] != 9 {
+		t.Errorf("Read %v, %v after losing the request, expected [9]", data, err)
+	}
+	emu.DropReplies(1)
+	if data, err := tg.ReadNow(reg, 1); err != nil || data[0] != 9 {
+		t.Errorf("Read %v, %v after losing the reply, expected [9]", data, err)
+	}
+
+	// A device that does not answer fails the read after waiting 50, 100
+	// and 200 ms.
+	for drained := false; !drained; {
+		select {
+		case <-tg.Errors():
+		default:
+			drained = true
+		}
+	}
+	emu.DropRequests(1000)
+	start := time.Now()
+	_, err = tg.ReadNow(reg, 1)
+	dt := time.Since(start)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Read from silent device gave %v, expected ErrTimeout", err)
+	}
+	if dt < 350*time.Millisecond || dt > 2*time.Second {
+		t.Errorf("Read from silent device failed after %v, expected 350 ms", dt)
+	}
+	emu.DropRequests(0)
+	waitreconnect(t, tg, ErrTimeout)
+	if data, err := tg.ReadNow(reg, 1); err != nil || data[0] != 9 {
+		t.Errorf("Read %v, %v after resynchronising, expected [9]", data, err)
+	}
+}
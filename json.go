@@ -0,0 +1,162 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A node of a JSON address table. The fields match the attributes of the
+// XML nodes, with tags as a list, parameters as an object and the child
+// nodes in children. Addresses and masks are strings so they can be given
+// in hex, e.g. "0x10".
+type jsonnode struct {
+	ID          string            `json:"id,omitempty"`
+	Address     string            `json:"address,omitempty"`
+	Mask        string            `json:"mask,omitempty"`
+	Module      string            `json:"module,omitempty"`
+	Permission  string            `json:"permission,omitempty"`
+	Mode        string            `json:"mode,omitempty"`
+	Size        int               `json:"size,omitempty"`
+	Description string            `json:"description,omitempty"`
+	FWInfo      string            `json:"fwinfo,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Parameters  map[string]string `json:"parameters,omitempty"`
+	Children    []*jsonnode       `json:"children,omitempty"`
+}
+
+// Address table files ending in .json are JSON, all others are XML.
+func isjson(fn string) bool {
+	return strings.ToLower(filepath.Ext(fn)) == ".json"
+}
+
+// Read the nodes of a JSON address table file as the elements they would be
+// in XML, so both formats are built into nodes the same way.
+func readjsonelements(fn string) (*element, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	root := &jsonnode{}
+	if err := json.Unmarshal(data, root); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return root.element(), nil
+}
+
+func (j *jsonnode) element() *element {
+	e := &element{attrs: make(map[string]string)}
+	attr := func(name, value string) {
+		if value != "" {
+			e.attrs[name] = value
+		}
+	}
+	attr("id", j.ID)
+	attr("address", j.Address)
+	attr("mask", j.Mask)
+	attr("module", j.Module)
+	attr("permission", j.Permission)
+	attr("mode", j.Mode)
+	if j.Size != 0 {
+		attr("size", fmt.Sprintf("%d", j.Size))
+	}
+	attr("description", j.Description)
+	attr("fwinfo", j.FWInfo)
+	attr("tags", strings.Join(j.Tags, ","))
+	attr("parameters", parameterstring(j.Parameters))
+	for _, c := range j.Children {
+		e.children = append(e.children, c.element())
+	}
+	return e
+}
+
+// Write n and every node below it to w as a single JSON address table, with
+// the contents of modules written in place of the module references.
+func (n *Node) WriteJSON(w io.Writer) error {
+	return writejson(w, n, nil)
+}
+
+// Write n as the JSON address table file fn, keeping the module layout it
+// was read with, like WriteXMLFiles.
+func (n *Node) WriteJSONFiles(fn string) error {
+	return n.writefile(fn, n.topdir(), make(map[string]string), writejson)
+}
+
+// Convert the address table file in, in XML or JSON, to out, in the format
+// given by its extension. Modules are written to files of their own next to
+// out, in the same format. Nothing is written if an attribute of in is
+// invalid, the *ValidationError returned lists the problems.
+func ConvertAddressTable(in, out string) error {
+	problems := []*AddressTableError{}
+	root, err := loadnode(in, nil, uint32(0), &problems)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		// Invalid attributes are left out of the tree, they would be
+		// lost in the conversion.
+		return &ValidationError{problems}
+	}
+	if isjson(out) {
+		return root.WriteJSONFiles(out)
+	}
+	return root.WriteXMLFiles(out)
+}
+
+func writejson(w io.Writer, n *Node, module func(*Node) (string, bool)) error {
+	data, err := json.MarshalIndent(tojson(n, module), "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func tojson(n *Node, module func(*Node) (string, bool)) *jsonnode {
+	j := &jsonnode{ID: n.Name, Mode: n.Mode, Description: n.Description,
+		FWInfo: n.FWInfo, Tags: n.Tags, Parameters: n.Parameters}
+	if n.parent != nil && !n.isfield() {
+		j.Address = fmt.Sprintf("0x%08x", n.Addr-n.parent.Addr)
+	}
+	if n.Mask != 0 {
+		j.Mask = fmt.Sprintf("0x%08x", n.Mask)
+	}
+	if n.Permission != ReadWrite {
+		j.Permission = n.Permission.String()
+	}
+	if n.hassize || (n.Size > 0 && !strings.Contains(n.FWInfo, "width")) {
+		j.Size = n.Size
+	}
+	if module != nil {
+		if modfn, ok := module(n); ok {
+			j.Module = "file://" + filepath.ToSlash(modfn)
+			return j
+		}
+	}
+	for _, c := range n.children {
+		j.Children = append(j.Children, tojson(c, module))
+	}
+	return j
+}
+
+// Parameters as the value of a parameters attribute, sorted by name.
+func parameterstring(pars map[string]string) string {
+	keys := make([]string, 0, len(pars))
+	for k := range pars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]string, len(keys))
+	for i, k := range keys {
+		kvs[i] = k + "=" + pars[k]
+	}
+	return strings.Join(kvs, ";")
+}
@@ -47,5 +47,5 @@ THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
 
 // Print the license the ipbus package is relased under.
 func License() {
-	fmt.Printf(license)
+	fmt.Print(license)
 }
@@ -0,0 +1,170 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Loopback is an in-process model of the registers behind a loopback
+// Target, for unit testing code that uses this package without a socket
+// or a device.
+//
+// Every address holds a 32-bit word, unwritten addresses read as zero.
+// Non-incrementing reads take values from a queue per address, filled by
+// non-incrementing writes and by Push, and read the stored word once the
+// queue is empty. RMWbits and RMWsum act on the stored word.
+type Loopback struct {
+	mu      sync.Mutex
+	mem     map[uint32]uint32
+	fifos   map[uint32][]uint32
+	codes   map[uint32]InfoCode
+	onread  func(addr, val uint32) uint32
+	onwrite func(addr, val uint32)
+}
+
+// Create a target described by the XML file fn whose transactions are
+// executed against an in-process register model instead of a device.
+// Use the returned Loopback to inspect and control the model. opts are
+// those of New.
+func NewLoopback(name, fn string, opts ...Option) (Target, *Loopback, error) {
+	lb := newloopback()
+	t, err := NewWithTransport(name, fn, NewMemoryTransport(lb.handle), opts...)
+	return t, lb, err
+}
+
+// Create an empty register model, for a transport to pass requests to.
+func newloopback() *Loopback {
+	return &Loopback{mem: make(map[uint32]uint32), fifos: make(map[uint32][]uint32),
+		codes: make(map[uint32]InfoCode)}
+}
+
+// Peek at the value stored at addr.
+func (lb *Loopback) Read(addr uint32) uint32 {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.mem[addr]
+}
+
+// Store val at addr.
+func (lb *Loopback) Write(addr, val uint32) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.mem[addr] = val
+}
+
+// Queue values to be returned by non-incrementing reads of addr.
+func (lb *Loopback) Push(addr uint32, vals ...uint32) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.fifos[addr] = append(lb.fifos[addr], vals...)
+}
+
+// Number of values queued for non-incrementing reads of addr.
+func (lb *Loopback) Queued(addr uint32) int {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return len(lb.fifos[addr])
+}
+
+// Set the masked part of reg to val, leaving the other bits unchanged.
+func (lb *Loopback) WriteMask(reg Register, mask string, val uint32) error {
+	andterm, orterm, err := reg.WriteMask(mask, val)
+	if err != nil {
+		return err
+	}
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.mem[reg.Addr] = (lb.mem[reg.Addr] & andterm) | orterm
+	return nil
+}
+
+// Get the masked part of reg.
+func (lb *Loopback) ReadMask(reg Register, mask string) (uint32, error) {
+	return reg.ReadMask(mask, lb.Read(reg.Addr))
+}
+
+// Answer every transaction touching addr with the info code, e.g.
+// BusReadError, instead of executing it. Use Success to clear it.
+func (lb *Loopback) Fail(addr uint32, code InfoCode) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if code == Success {
+		delete(lb.codes, addr)
+	} else {
+		lb.codes[addr] = code
+	}
+}
+
+// Call f for every word read from the model, with the value about to be
+// returned. The word sent in the reply is the value f returns, so f can
+// inject values. Pass nil to remove the hook.
+func (lb *Loopback) OnRead(f func(addr, val uint32) uint32) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.onread = f
+}
+
+// Call f for every word written to the model, after it is stored.
+// Pass nil to remove the hook.
+func (lb *Loopback) OnWrite(f func(addr, val uint32)) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.onwrite = f
+}
+
+func (lb *Loopback) handle(request []byte) []byte {
+	header, err := newPacketHeader(request)
+	if err != nil {
+		if verbose {
+			fmt.Printf("Loopback dropping packet: %v\n", err)
+		}
+		return nil
+	}
+	if header.ptype != control {
+		return nil
+	}
+	return execute(header, request[4:], lb)
+}
+
+// The hooks are called without holding the lock, so they may use the
+// Loopback methods.
+func (lb *Loopback) busread(addr uint32, noninc bool) (uint32, InfoCode) {
+	lb.mu.Lock()
+	if code, ok := lb.codes[addr]; ok {
+		lb.mu.Unlock()
+		return 0, code
+	}
+	val := lb.mem[addr]
+	if fifo := lb.fifos[addr]; noninc && len(fifo) > 0 {
+		val = fifo[0]
+		lb.fifos[addr] = fifo[1:]
+	}
+	onread := lb.onread
+	lb.mu.Unlock()
+	if onread != nil {
+		val = onread(addr, val)
+	}
+	return val, Success
+}
+
+func (lb *Loopback) buswrite(addr, val uint32, noninc bool) InfoCode {
+	lb.mu.Lock()
+	if code, ok := lb.codes[addr]; ok {
+		lb.mu.Unlock()
+		return code
+	}
+	lb.mem[addr] = val
+	if noninc {
+		lb.fifos[addr] = append(lb.fifos[addr], val)
+	}
+	onwrite := lb.onwrite
+	lb.mu.Unlock()
+	if onwrite != nil {
+		onwrite(addr, val)
+	}
+	return Success
+}
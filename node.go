@@ -0,0 +1,134 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Node is an element of the address table hierarchy, like a uHAL node. The
+// root node of a target is Target.Root; every register, mask, endpoint and
+// module below it is a Node.
+type Node struct {
+	Name        string // id attribute, empty for the root node
+	Addr        uint32 // Global IPbus address
+	Mask        uint32 // Bit mask, zero for unmasked nodes
+	Description string
+	Tags        []string
+	// Parameters of the node. For a module the parameters of the top node
+	// of the module file are merged with those of the including node,
+	// which take precedence.
+	Parameters map[string]string
+	// Address table file the node was loaded from if it is a module,
+	// otherwise empty.
+	Module     string
+	Permission Permission
+	Mode       string // incremental, non-incremental, port or single
+	Size       int    // Number of words, 2^N for fwinfo width=N, zero if not declared
+	FWInfo     string
+	parent     *Node
+	children   []*Node
+	hasaddr    bool
+	hassize    bool   // Size came from a size attribute, not an endpoint width
+	file       string // Address table file and line where the node is declared
+	line       int
+}
+
+// Find the descendant of n at path, a dotted list of names relative to n,
+// e.g. "chan.csr.ctrl".
+func (n *Node) Child(path string) (*Node, error) {
+	node := n
+	for _, name := range strings.Split(path, ".") {
+		var next *Node
+		for _, c := range node.children {
+			if c.Name == name {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("Node '%s' has no child '%s'.", n.Path(), path)
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// The immediate children of n in address table order.
+func (n *Node) Children() []*Node {
+	children := make([]*Node, len(n.children))
+	copy(children, n.children)
+	return children
+}
+
+// The node above n, nil for the root node.
+func (n *Node) Parent() *Node {
+	return n.parent
+}
+
+// Full dotted name of n from the root, as used for the keys of Target.Regs.
+// The root node has an empty path.
+func (n *Node) Path() string {
+	if n.parent == nil {
+		return ""
+	}
+	if p := n.parent.Path(); p != "" {
+		return p + "." + n.Name
+	}
+	return n.Name
+}
+
+// Call f for n and every node below it, parents before their children.
+func (n *Node) Walk(f func(*Node)) {
+	f(n)
+	for _, c := range n.children {
+		c.Walk(f)
+	}
+}
+
+// A mask only node is a bit field of its parent register rather than a
+// register of its own.
+func (n *Node) isfield() bool {
+	return n.Mask != 0 && !n.hasaddr && n.parent != nil
+}
+
+func (n *Node) String() string {
+	s := fmt.Sprintf("%s at 0x%x", n.Path(), n.Addr)
+	if n.Mask != 0 {
+		s += fmt.Sprintf(" mask 0x%x", n.Mask)
+	}
+	if n.Module != "" {
+		s += fmt.Sprintf(" (module %s)", n.Module)
+	}
+	return s
+}
+
+// Build the register for n, with any mask only children as its masks.
+func (n *Node) register() Register {
+	masks := make([]string, 0, 8)
+	msks := make(map[string]msk)
+	if n.Mask != 0 {
+		masks = append(masks, n.Name)
+		msks[n.Name] = newmask(n.Name, n.Mask)
+	}
+	for _, c := range n.children {
+		if c.isfield() {
+			masks = append(masks, c.Name)
+			msks[c.Name] = newmask(c.Name, c.Mask)
+		}
+	}
+	size := n.Size
+	if size == 0 {
+		size = 1
+	}
+	// The register gets a copy, so editing it leaves the address table as
+	// it is.
+	pars := make(map[string]string, len(n.Parameters))
+	for k, v := range n.Parameters {
+		pars[k] = v
+	}
+	return Register{n.Path(), n.Addr, masks, n.Permission, pars, isnoninc(n.Mode), size, msks}
+}
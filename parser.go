@@ -5,11 +5,11 @@
 package ipbus
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -19,207 +19,269 @@ type connectionset struct {
 	Conns []connection `xml:"connection"`
 }
 
-type nd struct {
-	id, description string
-	// localaddress is relative to either the bank or module that is the
-	// immediate parent of the register
-	localaddress uint32
+// An XML element of an address table file.
+type element struct {
+	attrs    map[string]string
+	children []*element
+	line     int
 }
 
-func newfile(id string, addr uint32, fn string) (file, error) {
-	return file{}, nil
+// Read the XML elements of an address table file, or of its XML equivalent
+// for a JSON file.
+func readelements(fn string) (*element, error) {
+	if isjson(fn) {
+		return readjsonelements(fn)
+	}
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	lines := &linecounter{data: data, line: 1}
+	stack := []*element{}
+	root := (*element)(nil)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fn, lines.at(dec.InputOffset()), err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			e := &element{attrs: make(map[string]string), line: lines.at(dec.InputOffset())}
+			for _, attr := range tok.Attr {
+				e.attrs[attr.Name.Local] = attr.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, e)
+			} else if root == nil {
+				root = e
+			}
+			stack = append(stack, e)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("%s: No nodes found.", fn)
+	}
+	return root, nil
 }
 
-// A single register description file.
-type file struct {
-	nd
-	fn        string
-	files     []file
-	modules   []mod
-	registers []reg
-	class     string
-}
+// Largest endpoint width, 2^30 words.
+const maxwidth = 30
 
-// A collection of registers
-type mod struct {
-	nd
-	description, fwinfo string
-	modules             []mod
-	registers           []reg
-}
+// Limit on modules including modules, to catch include cycles.
+const maxmoduledepth = 32
 
-type reg struct {
-	nd
-	masks       map[string]mask
-	read, write bool
-	mode        string
-	size        uint32
+// Line numbers of the bytes of data, asked for in increasing order of
+// offset so that each byte is only counted once.
+type linecounter struct {
+	data   []byte
+	offset int64
+	line   int
 }
 
-type mask struct {
-	id          string
-	val         uint32
-	description string
+// Line number of the byte at offset.
+func (l *linecounter) at(offset int64) int {
+	if offset > int64(len(l.data)) {
+		offset = int64(len(l.data))
+	}
+	if offset > l.offset {
+		l.line += bytes.Count(l.data[l.offset:offset], []byte("\n"))
+		l.offset = offset
+	}
+	return l.line
 }
 
-type node struct {
-	Id          string `xml:"id,attr"`
-	Addr        string `xml:"address,attr"`
-	Module      string `xml:"module,atr"`
-	Description string `xml:"description,attr"`
-	FWInfo      string `xml:"fwinfo,attr"`
-	Mode        string `xml:"mode,attr"`
-	Mask        string `xml:"mask,attr"`
-	Nodes       []node
+// Non-incremental registers, or ports, read and write every word at the
+// same address.
+func isnoninc(mode string) bool {
+	return mode == "port" || mode == "non-incremental" || mode == "non-inc"
 }
 
-type nodes struct {
-	Nodes []node
+// Parse the address table file fn into the node tree below t.Root and the
+// registers in t.Regs.
+func (t *Target) parseregfile(fn string) error {
+	problems := []*AddressTableError{}
+	root, err := loadnode(fn, nil, uint32(0), &problems)
+	if err != nil {
+		return err
+	}
+	t.Root = root
+	t.problems = problems
+	root.Walk(func(n *Node) {
+		if n.parent != nil && !n.isfield() {
+			t.Regs[n.Path()] = n.register()
+		}
+	})
+	return nil
 }
 
-type block struct {
-	id          string
-	address     uint32
-	description string
-	fwinfo      string
-	mode        string
+// Load the node tree of the address table file fn, in XML or JSON, without
+// creating a target. Invalid attributes, which are left out of the tree, are
+// listed in the *ValidationError returned with it. If strict it fails on any
+// problem Validate would find.
+func LoadAddressTable(fn string, strict bool) (*Node, error) {
+	problems := []*AddressTableError{}
+	root, err := loadnode(fn, nil, uint32(0), &problems)
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		return root, validate(root, problems)
+	}
+	if len(problems) > 0 {
+		return root, &ValidationError{problems}
+	}
+	return root, nil
 }
 
-func (b *block) register() Register {
-	msks := make(map[string]msk)
-	masks := make([]string, 0, 8)
-	noninc := b.mode == "port"
-	vals := strings.Split(b.fwinfo, ";")
-	size := 0
-	for _, v := range vals {
-		if strings.Contains(v, "width") {
-			vv := strings.Split(v, "=")
-			sizeval, _ := strconv.ParseInt(vv[1], 10, 32)
-			size = int(sizeval) + 1
-		}
+// Load the top node of address table file fn as a child of parent, at
+// address base. Invalid attributes are skipped and added to problems, other
+// errors stop the parsing.
+func loadnode(fn string, parent *Node, base uint32, problems *[]*AddressTableError) (*Node, error) {
+	e, err := readelements(fn)
+	if err != nil {
+		return nil, err
 	}
-	return Register{b.id, b.address, masks, noninc, size, msks}
+	return buildnode(e, fn, parent, base, problems)
 }
 
-func (t *Target) parseregfile(fn, basename string, filebaseaddr uint32) error {
-	//fmt.Printf("Parsing file '%s' with name '%s' at 0x%x\n", fn, basename, filebaseaddr)
-	inp, err := os.Open(fn)
-	if err != nil {
-		return err
+// Build the node for element e of file fn, and the nodes below it.
+func buildnode(e *element, fn string, parent *Node, base uint32, problems *[]*AddressTableError) (*Node, error) {
+	n := &Node{Name: e.attrs["id"], Addr: base, Parameters: make(map[string]string),
+		parent: parent, file: fn, line: e.line}
+	if parent == nil {
+		n.Name = ""
+	} else if n.Name == "" {
+		*problems = append(*problems, n.problem("Node has no id."))
 	}
-	dec := xml.NewDecoder(inp)
-	finished := false
-	depth := 0
-	tabs := ""
-	name := ""
-	if basename != "" {
-		name = basename
-	}
-	baseaddr := uint32(0)
-	localaddr := uint32(0)
-	currentblock := block{}
-	currentreg := Register{}
-	toplevel := true
-	for !finished {
-		tok, err := dec.Token()
-		if err == nil {
-			if start, ok := tok.(xml.StartElement); ok {
-				regtype := ""
-				module := ""
-				description := ""
-				fwinfo := ""
-				mode := ""
-				mask := uint32(0)
-				depth += 1
-				tabs += "\t"
-				msg := fmt.Sprintf("Start:%s%s, attr = ", tabs, start.Name.Local)
-				for _, attr := range start.Attr {
-					msg += fmt.Sprintf("%s: %s, ", attr.Name.Local, attr.Value)
-					n := attr.Name.Local
-					v := attr.Value
-					switch {
-					case n == "id":
-						if toplevel {
-							toplevel = false
-						} else {
-							if v == "TOP" {
-								regtype = "TOP"
-							} else {
-								if name != "" {
-									name += "." + v
-								} else {
-									name = v
-								}
-							}
-						}
-					case n == "address":
-						addr, _ := strconv.ParseUint(attr.Value, 0, 32)
-						localaddr = uint32(addr)
-					case n == "mask":
-						regtype = "mask"
-						maskval, _ := strconv.ParseUint(v, 0, 32)
-						mask = uint32(maskval)
-					case n == "module":
-						regtype = "mod"
-						module = v
-					case n == "description":
-						description = v
-					case n == "fwinfo":
-						regtype = "blk"
-						fwinfo = v
-					case n == "mode":
-						mode = v
-					}
+	// The top node of a module file can itself be a module.
+	for depth := 0; ; depth++ {
+		module, ok := e.attrs["module"]
+		if !ok {
+			break
+		}
+		if depth == maxmoduledepth {
+			return nil, fmt.Errorf("%s:%d: Modules nested more than %d deep.", fn, e.line, maxmoduledepth)
+		}
+		modfn := strings.Replace(module, "file://", "", 1)
+		modfn = filepath.Join(filepath.Dir(fn), modfn)
+		mod, err := readelements(modfn)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fn, e.line, err)
+		}
+		// Attributes of the including node override those of the top node
+		// of the module. Parameters are merged, with those of the including
+		// node taking precedence since later parameters override earlier ones.
+		merged := &element{attrs: make(map[string]string), children: mod.children, line: e.line}
+		for k, v := range mod.attrs {
+			merged.attrs[k] = v
+		}
+		for k, v := range e.attrs {
+			if k != "module" {
+				merged.attrs[k] = v
+			}
+		}
+		if mp, ok := mod.attrs["parameters"]; ok {
+			if ep, ok := e.attrs["parameters"]; ok {
+				merged.attrs["parameters"] = mp + ";" + ep
+			}
+		}
+		e = merged
+		fn = modfn
+		if n.Module == "" {
+			n.Module = modfn
+		}
+	}
+	fail := func(format string, args ...interface{}) {
+		*problems = append(*problems, n.problem(format, args...))
+	}
+	for k, v := range e.attrs {
+		switch k {
+		case "address":
+			addr, err := strconv.ParseUint(v, 0, 32)
+			if err != nil {
+				fail("Invalid address '%s'.", v)
+				continue
+			}
+			n.Addr = base + uint32(addr)
+			n.hasaddr = true
+		case "mask":
+			mask, err := strconv.ParseUint(v, 0, 32)
+			if err != nil {
+				fail("Invalid mask '%s'.", v)
+				continue
+			}
+			n.Mask = uint32(mask)
+		case "permission":
+			p, err := parsepermission(v)
+			if err != nil {
+				fail("%v", err)
+				continue
+			}
+			n.Permission = p
+		case "size":
+			size, err := strconv.ParseUint(v, 0, 32)
+			if err != nil {
+				fail("Invalid size '%s'.", v)
+				continue
+			}
+			n.Size = int(size)
+			n.hassize = true
+		case "mode":
+			n.Mode = v
+		case "description":
+			n.Description = v
+		case "fwinfo":
+			n.FWInfo = v
+		case "tags":
+			for _, tag := range strings.Split(v, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					n.Tags = append(n.Tags, tag)
 				}
-				if regtype == "" {
-					regtype = "reg"
+			}
+		case "parameters":
+			for _, par := range strings.Split(v, ";") {
+				if par = strings.TrimSpace(par); par == "" {
+					continue
 				}
-				switch {
-				case regtype == "blk":
-					baseaddr = localaddr + filebaseaddr
-					localaddr = uint32(0)
-					if currentblock.id != "" {
-						t.Regs[currentblock.id] = currentblock.register()
-					}
-					currentblock = block{name, baseaddr + localaddr, description, fwinfo, mode}
-					//fmt.Printf("Found block: '%s' at 0x%x -> 0x%x\n", name, localaddr, baseaddr + localaddr)
-				case regtype == "reg":
-					if currentreg.Name != "" {
-						t.Regs[currentreg.Name] = currentreg
-					}
-					masks := make([]string, 0, 8)
-					msks := make(map[string]msk)
-					noninc := mode == "port"
-					currentreg = Register{name, baseaddr + localaddr, masks, noninc, 1, msks}
-				case regtype == "mask":
-					names := strings.Split(name, ".")
-					maskname := names[len(names)-1]
-					currentreg.Masks = append(currentreg.Masks, maskname)
-					currentreg.msks[maskname] = newmask(maskname, mask)
-				case regtype == "mod":
-					modfn := strings.Replace(module, "file://", "", 1)
-					dir, _ := filepath.Split(fn)
-					modfn = filepath.Join(dir, modfn)
-					if err := t.parseregfile(modfn, name, localaddr+filebaseaddr); err != nil {
-						return err
-					}
+				kv := strings.SplitN(par, "=", 2)
+				if len(kv) != 2 {
+					fail("Invalid parameter '%s'.", par)
+					continue
 				}
-			} else if _, ok := tok.(xml.EndElement); ok {
-				depth -= 1
-				tabs = strings.Replace(tabs, "\t", "", 1)
-				names := strings.Split(name, ".")
-				name = strings.Join(names[:len(names)-1], ".")
+				n.Parameters[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
 			}
-		} else {
-			finished = true
-			if err != io.EOF {
-				return err
+		}
+	}
+	if n.Size == 0 {
+		// An endpoint of width N spans 2^N words, as in uHAL. A wrong
+		// size would bounds check block transfers wrongly, so a bad width
+		// stops the parsing. Sizes must fit an int on 32-bit platforms.
+		for _, v := range strings.Split(n.FWInfo, ";") {
+			kv := strings.SplitN(v, "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "width" {
+				width, err := strconv.ParseUint(strings.TrimSpace(kv[1]), 0, 5)
+				if err != nil || width > maxwidth {
+					return nil, n.problem("Invalid endpoint width '%s'.", kv[1])
+				}
+				n.Size = 1 << width
 			}
 		}
 	}
-	t.Regs[currentreg.Name] = currentreg
-	t.Regs[currentblock.id] = currentblock.register()
-	return error(nil)
+	for _, c := range e.children {
+		child, err := buildnode(c, fn, n, n.Addr, problems)
+		if err != nil {
+			return nil, err
+		}
+		n.children = append(n.children, child)
+	}
+	return n, nil
 }
 
 // Parse an XML file description of the target to automatically produce
@@ -236,7 +298,7 @@ func (t *Target) parse(fn string) error {
 			t.dest = strings.Replace(conn.URI, "ipbusudp-2.0://", "", 1)
 			//ns := nodes{}
 			addr := strings.Replace(conn.Address, "file://", "", 1)
-			if err := t.parseregfile(addr, "", uint32(0)); err != nil {
+			if err := t.parseregfile(addr); err != nil {
 				return err
 			}
 		}
@@ -5,6 +5,14 @@
 package ipbus_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/go-daq/ipbus"
@@ -30,14 +38,12 @@ func TestParserMissingTarget(t *testing.T) {
 }
 
 func TestParser8chan(t *testing.T) {
-	cm, err := ipbus.NewCM("testdata/8chanxml/solidfpga.xml")
-	if err != nil {
-		t.Fatal(err)
-	}
-	target, err := cm.Target("SoLidFPGA")
+	// The address table is parsed without a device to talk to.
+	target, _, err := ipbus.NewLoopback("SoLidFPGA", "testdata/8chanxml/addr_table/top.xml")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer target.Close()
 	expectedregs := make(map[string]uint32)
 	expectedregs["ctrl_reg"] = uint32(0x0)
 	expectedregs["ctrl_reg.ctrl"] = uint32(0x0)
@@ -112,11 +118,12 @@ func TestParser(t *testing.T) {
 		return
 	}
 	t.Logf("Device list: %v\n", cm.Devices)
-	target, err := cm.Target("GLIB")
+	target, _, err := ipbus.NewLoopback("GLIB", "testdata/xml/addr_table/sc_daq.xml")
 	if err != nil {
 		t.Fatal(err)
 		return
 	}
+	defer target.Close()
 	expectedregs := make(map[string]bool)
 	expectedregs["id"] = true
 	expectedregs["id.magic"] = true
@@ -150,3 +157,454 @@ func TestParser(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestNodeTree(t *testing.T) {
+	target, _, err := ipbus.NewLoopback("SoLidFPGA", "testdata/8chanxml/addr_table/top.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	ctrl, err := target.Root.Child("io.clock_i2c.ctrl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctrl.Addr != 0x2a || ctrl.Description != "Control" || ctrl.Path() != "io.clock_i2c.ctrl" {
+		t.Errorf("Found %v, description '%s', expected io.clock_i2c.ctrl at 0x2a.", ctrl, ctrl.Description)
+	}
+	i2c := ctrl.Parent()
+	if !strings.HasSuffix(i2c.Module, "opencores_i2c.xml") {
+		t.Errorf("Node %v has module '%s', expected opencores_i2c.xml", i2c, i2c.Module)
+	}
+	if _, err := i2c.Child("missing"); err == nil {
+		t.Errorf("No error finding missing child of %v.", i2c)
+	}
+	fields := []string{}
+	node, err := target.Root.Child("ctrl_reg.ctrl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range node.Children() {
+		fields = append(fields, c.Name)
+	}
+	expected := "soft_rst nuke rst_mmcm rst_idelayctrl chan board_id"
+	if strings.Join(fields, " ") != expected {
+		t.Errorf("ctrl_reg.ctrl has children %v, expected %s", fields, expected)
+	}
+	nuke := node.Children()[1]
+	if nuke.Mask != 0x2 || nuke.Addr != 0x0 {
+		t.Errorf("Found %v, expected mask 0x2 at 0x0.", nuke)
+	}
+	reg := target.Regs["ctrl_reg.ctrl"]
+	if len(reg.Masks) != 6 {
+		t.Errorf("Register %v has %d masks, expected 6.", reg, len(reg.Masks))
+	}
+
+	dummy, _, err := ipbus.NewLoopback("dummy", "testdata/xml/dummy_address.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pars, err := dummy.Root.Child("REG_PARS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pars.Parameters["arg0"] != "val100" || pars.Parameters["arg1"] != "val101" {
+		t.Errorf("REG_PARS has parameters %v, expected arg0=val100;arg1=val101", pars.Parameters)
+	}
+	fifo, err := dummy.Root.Child("FIFO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fifo.Tags) != 1 || fifo.Tags[0] != "test" || fifo.Size != 268435456 {
+		t.Errorf("FIFO has tags %v and size %d, expected [test] and 268435456", fifo.Tags, fifo.Size)
+	}
+	mem, err := dummy.Root.Child("SUBSYSTEM1.SUBMODULE.MEM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.Addr != 0x260002 {
+		t.Errorf("SUBSYSTEM1.SUBMODULE.MEM at 0x%x, expected 0x260002", mem.Addr)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	target, _, err := ipbus.NewLoopback("dummy", "testdata/xml/dummy_address.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func(regs []ipbus.Register) string {
+		s := []string{}
+		for _, reg := range regs {
+			s = append(s, reg.Name)
+		}
+		return strings.Join(s, " ")
+	}
+	regs, err := target.Match(`SUBSYSTEM[12]\.(SUBMODULE\.)?REG`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "SUBSYSTEM1.REG SUBSYSTEM1.SUBMODULE.REG SUBSYSTEM2.REG SUBSYSTEM2.SUBMODULE.REG"
+	if got := names(regs); got != expected {
+		t.Errorf("Regex query found %s, expected %s", got, expected)
+	}
+	if _, err := target.Match("REG("); err == nil {
+		t.Errorf("No error for invalid regular expression.")
+	}
+	regs = target.Tagged("test")
+	for i, reg := range regs {
+		if i > 0 && reg.Addr < regs[i-1].Addr {
+			t.Errorf("Tag query not in address order: %s", names(regs))
+			break
+		}
+	}
+	if len(regs) == 0 || regs[0].Name != "REG" || regs[1].Name != "FIFO" {
+		t.Errorf("Tag query found %s, expected REG FIFO ... first", names(regs))
+	}
+	regs = target.WithPermission(ipbus.ReadOnly)
+	if got := names(regs); got != "REG_READ_ONLY" {
+		t.Errorf("Permission query found %s, expected REG_READ_ONLY", got)
+	}
+}
+
+func TestParameters(t *testing.T) {
+	target, _, err := ipbus.NewLoopback("dummy", "testdata/xml/dummy_address.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]string{
+		"REG_PARS":             {"arg0": "val100", "arg1": "val101"},
+		"SUBSYSTEM1":           {"arg0": "val200", "arg1": "val201", "arg2": "val202"},
+		"SUBSYSTEM2":           {"arg0": "val10000", "arg1": "val201", "arg2": "val202", "arg5": "val10005"},
+		"SUBSYSTEM1.SUBMODULE": {"arg0": "val300", "arg1": "val301", "arg2": "val10302", "arg3": "val10303"},
+		"SUBSYSTEM1.REG":       {},
+	}
+	for name, pars := range expected {
+		reg, ok := target.Regs[name]
+		if !ok {
+			t.Errorf("Register '%s' not found.", name)
+			continue
+		}
+		if len(reg.Parameters) != len(pars) {
+			t.Errorf("Register '%s' has parameters %v, expected %v", name, reg.Parameters, pars)
+			continue
+		}
+		for k, v := range pars {
+			if reg.Parameters[k] != v {
+				t.Errorf("Register '%s' has parameters %v, expected %v", name, reg.Parameters, pars)
+				break
+			}
+		}
+	}
+	target.Regs["REG_PARS"].Parameters["arg0"] = "changed"
+	node, err := target.Root.Child("REG_PARS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Parameters["arg0"] != "val100" {
+		t.Errorf("Changing the parameters of a register changed its node to %v", node.Parameters)
+	}
+}
+
+func TestEndpointWidth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipbus-width-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(width string) string {
+		fn := filepath.Join(dir, "width"+width+".xml")
+		table := `<node id="top"><node id="mem" address="0x0" fwinfo="endpoint;width=` + width + `"/></node>`
+		if err := ioutil.WriteFile(fn, []byte(table), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+	root, err := ipbus.LoadAddressTable(write("3"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem, err := root.Child("mem"); err != nil || mem.Size != 8 {
+		t.Errorf("Endpoint of width 3 has %v, %v, expected 8 words", mem, err)
+	}
+	for _, width := range []string{"x", "31"} {
+		_, err = ipbus.LoadAddressTable(write(width), false)
+		terr := &ipbus.AddressTableError{}
+		if !errors.As(err, &terr) || terr.Line != 1 {
+			t.Errorf("Loading endpoint of width %s gave %v, expected an AddressTableError on line 1", width, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, fn := range []string{"testdata/8chanxml/addr_table/top.xml", "testdata/xml/addr_table/sc_daq.xml"} {
+		if err := ipbus.ValidateAddressTable(fn); err != nil {
+			t.Errorf("Unexpected problems in %s: %v", fn, err)
+		}
+	}
+	err := ipbus.ValidateAddressTable("testdata/xml/broken_address.xml")
+	verr, ok := err.(*ipbus.ValidationError)
+	if !ok {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	expected := []string{
+		"testdata/xml/broken_address.xml:5",  // BAD_ADDRESS
+		"testdata/xml/broken_address.xml:7",  // REG_B shares address with REG_A
+		"testdata/xml/broken_address.xml:11", // WIDE overlaps LOW and MID
+		"testdata/xml/broken_address.xml:11",
+		"testdata/xml/broken_address.xml:15", // MODULE outside BLOCK
+	}
+	found := []string{}
+	for _, p := range verr.Problems {
+		t.Log(p)
+		found = append(found, fmt.Sprintf("%s:%d", p.File, p.Line))
+	}
+	sort.Strings(found)
+	sort.Strings(expected)
+	if strings.Join(found, " ") != strings.Join(expected, " ") {
+		t.Errorf("Found problems at %v, expected %v", found, expected)
+	}
+
+	if _, _, err := ipbus.NewLoopback("broken", "testdata/xml/broken_address.xml"); err != nil {
+		t.Errorf("Problems are not fatal unless strict: %v", err)
+	}
+	if _, _, err := ipbus.NewLoopback("broken", "testdata/xml/broken_address.xml", ipbus.WithStrictAddressTable()); err == nil {
+		t.Errorf("No error for broken address table in strict mode.")
+	}
+	// Only the invalid attributes are problems without strict validation.
+	_, err = ipbus.LoadAddressTable("testdata/xml/broken_address.xml", false)
+	verr = &ipbus.ValidationError{}
+	if !errors.As(err, &verr) || len(verr.Problems) != 1 || verr.Problems[0].Line != 5 {
+		t.Errorf("Loading broken address table gave %v, expected the invalid address on line 5", err)
+	}
+}
+
+func TestWriteXML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipbus-writer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i, fn := range []string{"testdata/8chanxml/addr_table/top.xml", "testdata/xml/dummy_address.xml"} {
+		orig, _, err := ipbus.NewLoopback("orig", fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flat := filepath.Join(dir, fmt.Sprintf("flat%d.xml", i))
+		f, err := os.Create(flat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := orig.Root.WriteXML(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		multi := filepath.Join(dir, fmt.Sprintf("multi%d", i), "top.xml")
+		if err := orig.Root.WriteXMLFiles(multi); err != nil {
+			t.Fatal(err)
+		}
+		for _, out := range []string{flat, multi} {
+			written, _, err := ipbus.NewLoopback("written", out)
+			if err != nil {
+				t.Fatalf("Failed to load %s written from %s: %v", out, fn, err)
+			}
+			compareregs(t, out, orig.Regs, written.Regs)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "multi1", "dummy_level2_address.xml")); err != nil {
+		t.Errorf("Module file not written: %v", err)
+	}
+}
+
+func TestBuildTable(t *testing.T) {
+	root := &ipbus.Node{}
+	csr := &ipbus.Node{Name: "csr", Addr: 0x10}
+	root.AddChild(csr)
+	// A masked register at the address of its module, not a bit field.
+	csr.AddChild(&ipbus.Node{Name: "ctrl", Addr: 0x10, Mask: 0xff})
+	stat := &ipbus.Node{Name: "stat", Addr: 0x11, Permission: ipbus.ReadOnly}
+	csr.AddChild(stat)
+	stat.AddField(&ipbus.Node{Name: "ready", Mask: 0x1, Permission: ipbus.ReadOnly})
+
+	buf := &bytes.Buffer{}
+	if err := root.WriteXML(buf); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "ipbus-build-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "built.xml")
+	if err := ioutil.WriteFile(fn, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := ipbus.NewLoopback("built", fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parsed.Close()
+	if reg, ok := parsed.Regs["csr.ctrl"]; !ok || reg.Addr != 0x10 {
+		t.Errorf("Register csr.ctrl parsed as %v, %v, expected it at 0x10", reg, ok)
+	}
+	if reg, ok := parsed.Regs["csr.stat"]; !ok || len(reg.Masks) != 1 || reg.Masks[0] != "ready" {
+		t.Errorf("Register csr.stat parsed as %v, %v, expected bit field ready", reg, ok)
+	}
+	if _, ok := parsed.Regs["csr.stat.ready"]; ok {
+		t.Errorf("Bit field csr.stat.ready parsed as a register.")
+	}
+	rewritten := &bytes.Buffer{}
+	if err := parsed.Root.WriteXML(rewritten); err != nil {
+		t.Fatal(err)
+	}
+	if rewritten.String() != buf.String() {
+		t.Errorf("Parsed table written as\n%s\nexpected\n%s", rewritten, buf)
+	}
+}
+
+func TestWriteModuleOutside(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipbus-outside-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"src/top.xml":    `<node><node id="sub" address="0x10" module="file://../common/sub.xml"/></node>`,
+		"common/sub.xml": `<node><node id="reg" address="0x1"/></node>`,
+	}
+	for fn, table := range files {
+		fn = filepath.Join(dir, fn)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(table), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orig, _, err := ipbus.NewLoopback("orig", filepath.Join(dir, "src/top.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+	out := filepath.Join(dir, "out", "top.json")
+	if err := orig.Root.WriteJSONFiles(out); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "common", "sub.json")); err == nil {
+		t.Errorf("Module written outside the output directory.")
+	}
+	written, _, err := ipbus.NewLoopback("written", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer written.Close()
+	compareregs(t, out, orig.Regs, written.Regs)
+}
+
+func compareregs(t *testing.T, fn string, expected, found map[string]ipbus.Register) {
+	if len(expected) != len(found) {
+		t.Errorf("%s has %d registers, expected %d", fn, len(found), len(expected))
+	}
+	for name, e := range expected {
+		f, ok := found[name]
+		if !ok {
+			t.Errorf("%s has no register '%s'", fn, name)
+			continue
+		}
+		if f.Addr != e.Addr || f.Permission != e.Permission || f.Size() != e.Size() ||
+			fmt.Sprint(f.Masks) != fmt.Sprint(e.Masks) || fmt.Sprint(f.Parameters) != fmt.Sprint(e.Parameters) {
+			t.Errorf("%s has register %v, expected %v", fn, f, e)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipbus-json-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i, fn := range []string{"testdata/8chanxml/addr_table/top.xml", "testdata/xml/dummy_address.xml"} {
+		orig, _, err := ipbus.NewLoopback("orig", fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flat := filepath.Join(dir, fmt.Sprintf("flat%d.json", i))
+		f, err := os.Create(flat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := orig.Root.WriteJSON(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		multi := filepath.Join(dir, fmt.Sprintf("multi%d", i), "top.json")
+		if err := ipbus.ConvertAddressTable(fn, multi); err != nil {
+			t.Fatal(err)
+		}
+		back := filepath.Join(dir, fmt.Sprintf("back%d", i), "top.xml")
+		if err := ipbus.ConvertAddressTable(multi, back); err != nil {
+			t.Fatal(err)
+		}
+		for _, out := range []string{flat, multi, back} {
+			converted, _, err := ipbus.NewLoopback("converted", out)
+			if err != nil {
+				t.Fatalf("Failed to load %s converted from %s: %v", out, fn, err)
+			}
+			compareregs(t, out, orig.Regs, converted.Regs)
+		}
+	}
+	for _, fn := range []string{"multi1/dummy_level2_address.json", "back1/dummy_level2_address.xml"} {
+		if _, err := os.Stat(filepath.Join(dir, fn)); err != nil {
+			t.Errorf("Module file not written: %v", err)
+		}
+	}
+
+	broken := filepath.Join(dir, "broken.json")
+	err = ipbus.ConvertAddressTable("testdata/xml/broken_address.xml", broken)
+	verr := &ipbus.ValidationError{}
+	if !errors.As(err, &verr) {
+		t.Errorf("Converting table with invalid attributes gave %v, expected a ValidationError", err)
+	}
+	if _, err := os.Stat(broken); err == nil {
+		t.Errorf("Table with invalid attributes converted to %s.", broken)
+	}
+}
+
+func TestWriteGo(t *testing.T) {
+	fn := "testdata/8chanxml/addr_table/top.xml"
+	root, err := ipbus.LoadAddressTable(fn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := root.WriteGo(buf, "solidfpga", "FPGA", fn); err != nil {
+		t.Fatal(err)
+	}
+	committed, err := ioutil.ReadFile("internal/solidfpga/fpga.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), committed) {
+		t.Errorf("internal/solidfpga/fpga.go is out of date, run go generate.")
+	}
+}
+
+func TestWriteGoRootFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "fields.xml")
+	table := `<node id="top"><node id="enable" mask="0x1"/></node>`
+	if err := ioutil.WriteFile(fn, []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root, err := ipbus.LoadAddressTable(fn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = root.WriteGo(&bytes.Buffer{}, "fields", "Fields", fn)
+	if err == nil || !strings.Contains(err.Error(), "enable") {
+		t.Errorf("Generating code for bit fields below the root gave %v, expected an error naming the field.", err)
+	}
+}
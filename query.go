@@ -0,0 +1,65 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"regexp"
+	"sort"
+)
+
+// Registers whose dotted path fully matches the regular expression expr,
+// like uHAL's getNodes. For example ".*\.stat" finds every stat register.
+func (t Target) Match(expr string) ([]Register, error) {
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return t.selectregs(func(n *Node) bool {
+		return re.MatchString(n.Path())
+	}), nil
+}
+
+// Registers carrying tag in their tags attribute.
+func (t Target) Tagged(tag string) []Register {
+	return t.selectregs(func(n *Node) bool {
+		for _, nt := range n.Tags {
+			if nt == tag {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Registers with permission p.
+func (t Target) WithPermission(p Permission) []Register {
+	return t.selectregs(func(n *Node) bool {
+		return n.Permission == p
+	})
+}
+
+// Registers whose nodes satisfy keep, in address order. Registers sharing
+// an address are ordered by name.
+func (t Target) selectregs(keep func(*Node) bool) []Register {
+	regs := []Register{}
+	if t.Root == nil {
+		return regs
+	}
+	t.Root.Walk(func(n *Node) {
+		if n.parent == nil || n.isfield() || !keep(n) {
+			return
+		}
+		if reg, ok := t.Regs[n.Path()]; ok {
+			regs = append(regs, reg)
+		}
+	})
+	sort.SliceStable(regs, func(i, j int) bool {
+		if regs[i].Addr != regs[j].Addr {
+			return regs[i].Addr < regs[j].Addr
+		}
+		return regs[i].Name < regs[j].Name
+	})
+	return regs
+}
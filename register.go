@@ -6,6 +6,7 @@ package ipbus
 
 import (
 	"fmt"
+	"strings"
 )
 
 func newmask(name string, value uint32) msk {
@@ -26,13 +27,91 @@ type msk struct {
 	shift uint
 }
 
+// Access rights of a register, from the permission attribute of the
+// address table. Registers without a permission attribute are read-write.
+type Permission uint8
+
+const (
+	ReadWrite Permission = iota
+	ReadOnly
+	WriteOnly
+)
+
+func (p Permission) String() string {
+	switch p {
+	case ReadWrite:
+		return "rw"
+	case ReadOnly:
+		return "r"
+	case WriteOnly:
+		return "w"
+	}
+	return fmt.Sprintf("Permission(%d)", uint8(p))
+}
+
+// Readable reports whether the register can be read.
+func (p Permission) Readable() bool {
+	return p != WriteOnly
+}
+
+// Writable reports whether the register can be written.
+func (p Permission) Writable() bool {
+	return p != ReadOnly
+}
+
+// Parse a permission attribute as accepted by uHAL.
+func parsepermission(v string) (Permission, error) {
+	switch strings.ToLower(v) {
+	case "r", "read":
+		return ReadOnly, nil
+	case "w", "write":
+		return WriteOnly, nil
+	case "rw", "wr", "readwrite", "writeread":
+		return ReadWrite, nil
+	}
+	return ReadWrite, fmt.Errorf("Invalid permission '%s'.", v)
+}
+
 type Register struct {
-	Name   string
-	Addr   uint32   // Global IPbus address
-	Masks  []string // List of names bitmasks
-	noninc bool
-	size   int
-	msks   map[string]msk
+	Name       string
+	Addr       uint32     // Global IPbus address
+	Masks      []string   // List of names bitmasks
+	Permission Permission // Access rights
+	// Parameters from the address table, including those set where the
+	// register's module is included.
+	Parameters map[string]string
+	noninc     bool
+	size       int
+	msks       map[string]msk
+}
+
+// PermissionError is returned when a transaction would read a write-only
+// register or write a read-only one. Nothing is sent to the device.
+type PermissionError struct {
+	Register Register
+	Op       string // "read" or "write"
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("Cannot %s register %s at 0x%x with permission '%v'.", e.Op, e.Register.Name, e.Register.Addr, e.Register.Permission)
+}
+
+// SizeError is returned when a block transfer would run past the end of an
+// incrementing register. Nothing is sent to the device.
+type SizeError struct {
+	Register Register
+	Words    uint // Number of words requested
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("Transfer of %d words overruns register %s at 0x%x of %d words.", e.Words, e.Register.Name, e.Register.Addr, e.Register.size)
+}
+
+// Number of words in the register, from the size attribute of the address
+// table, or 2^N for an endpoint with fwinfo "width=N". Single registers have
+// a size of one.
+func (r Register) Size() int {
+	return r.size
 }
 
 func (r Register) String() string {
@@ -40,6 +119,9 @@ func (r Register) String() string {
 	if r.noninc {
 		s += " (non-inc)"
 	}
+	if r.Permission != ReadWrite {
+		s += fmt.Sprintf(" (%v)", r.Permission)
+	}
 	if len(r.Masks) > 0 {
 		s += " ["
 	}
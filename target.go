@@ -5,6 +5,7 @@
 package ipbus
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"sort"
@@ -14,6 +15,78 @@ import (
 const DefaultTimeout = 3 * time.Second
 const DefaultAutoDispatch = false
 
+// RetryPolicy sets how a target recovers packets lost over UDP. A packet not
+// replied to within Timeout is lost: the device is asked for its status and
+// the packet, or its reply, is sent again. Each further attempt waits Backoff
+// times longer than the one before. Once MaxResends attempts have failed the
+// transactions in flight fail with an error wrapping ErrTimeout and the
+// target resynchronises with the device from a status packet.
+type RetryPolicy struct {
+	MaxResends int
+	Timeout    time.Duration
+	Backoff    float64
+}
+
+// Retry policy of new targets.
+var DefaultRetryPolicy = RetryPolicy{MaxResends: 3, Timeout: DefaultTimeout, Backoff: 1.5}
+
+// Option changes how a new target is set up, see New.
+type Option func(*options)
+
+type options struct {
+	handshake time.Duration
+	policy    RetryPolicy
+	strict    bool
+}
+
+// Time to wait for a UDP device to answer a status request, which gives the
+// packet ID it expects, before giving up with an error wrapping ErrTimeout.
+// It is DefaultTimeout unless set to a positive time.
+func WithHandshakeTimeout(dt time.Duration) Option {
+	return func(o *options) {
+		o.handshake = dt
+	}
+}
+
+// Validate the address table and fail if it has any problems, see
+// Target.Validate.
+func WithStrictAddressTable() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// Recover lost packets according to p instead of DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// Time to wait for a reply after the given number of failed attempts.
+func (p RetryPolicy) wait(attempts int) time.Duration {
+	dt := float64(p.Timeout)
+	for i := 0; i < attempts; i++ {
+		dt *= p.Backoff
+	}
+	return time.Duration(dt)
+}
+
+// The policy with out of range values replaced: no resends, the default
+// timeout and no backoff.
+func (p RetryPolicy) valid() RetryPolicy {
+	if p.MaxResends < 0 {
+		p.MaxResends = 0
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = DefaultTimeout
+	}
+	if p.Backoff < 1 {
+		p.Backoff = 1
+	}
+	return p
+}
+
 type Target struct {
 	Name string
 	// TimeoutPeriod defines the period to wait after queuing an initial transaction
@@ -21,44 +94,99 @@ type Target struct {
 	// The Timeout is restarted each time a transaction is added when the queue is empty.
 	TimeoutPeriod time.Duration
 	Regs          map[string]Register
+	// Root of the address table hierarchy, Regs holds the same registers
+	// keyed by their dotted paths.
+	Root *Node
 	// Enable/disable automatic dispatch of transactions.
 	// If enabled transactions are sent at the first opportunity when:
 	// a) A full UDP packet worth of transactions can be sent
-	// b) Target.dt has elapsed since the first queued transaction
+	// b) TimeoutPeriod has elapsed since the first queued transaction
 	// or
-	// c) Target.Dispatch() is called.
-	// If disabled transactions are only sent when Target.Dispatch() is called.
-	AutoDispatch        bool
+	// c) Target.Dispatch() or Target.Flush() is called.
+	// If disabled transactions are only sent when Target.Dispatch() or
+	// Target.Flush() is called.
+	// AutoDispatch and TimeoutPeriod apply to the transactions queued
+	// through a Target with them set.
+	AutoDispatch bool
+	// Shorten block transfers that would run past the end of an
+	// incrementing register instead of rejecting them with a *SizeError.
+	ClampTransfers      bool
 	dest                string
 	outgoing, inflight  []packet
 	nextoutid, nextinid uint32
 	requests            chan usrrequest
 	finishpacket, stop  chan bool
+	stopped             chan bool // Closed once preparepackets returns
 	hw                  *hw
+	packetsize          int
+	problems            []*AddressTableError
 	Addr                net.Addr
 }
 
-// Create a new target by parsing an XML file description.
-func New(name, fn string, conn net.Conn) (Target, error) {
+// Create a new target by parsing an XML file description. A TCP connection
+// uses length prefixed packets, any other connection one packet per datagram.
+// An error is returned if a UDP device does not answer, see
+// WithHandshakeTimeout.
+func New(name, fn string, conn net.Conn, opts ...Option) (Target, error) {
+	return NewWithTransport(name, fn, newconntransport(conn), opts...)
+}
+
+// Create a new target by parsing an XML file description, talking to the
+// device through tr. Over an unreliable transport the device must answer a
+// status request, see WithHandshakeTimeout.
+func NewWithTransport(name, fn string, tr Transport, opts ...Option) (Target, error) {
+	o := options{DefaultTimeout, DefaultRetryPolicy, false}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.handshake <= 0 {
+		o.handshake = DefaultTimeout
+	}
 	regs := make(map[string]Register)
 	reqs := make(chan usrrequest)
 	fp := make(chan bool)
 	stop := make(chan bool)
+	stopped := make(chan bool)
 
-	raddr := conn.RemoteAddr()
-	t := Target{Name: name, Regs: regs, requests: reqs, finishpacket: fp, stop: stop, Addr: raddr}
+	raddr := tr.RemoteAddr()
+	t := Target{Name: name, Regs: regs, requests: reqs, finishpacket: fp, stop: stop, stopped: stopped, Addr: raddr}
 	t.TimeoutPeriod = DefaultTimeout
 	t.AutoDispatch = DefaultAutoDispatch
-	t.hw = newhw(conn, t.TimeoutPeriod)
+	t.packetsize = tr.MaxPacketSize()
+	t.hw = newhw(tr, o.policy)
 	go t.preparepackets()
 	if verbose {
 		t.hw.SetVerbose(1)
 	}
+	go t.hw.receive()
+	err := t.hw.ConfigDevice(o.handshake)
 	go t.hw.Run()
-	err := t.parseregfile(fn, "", uint32(0))
+	if err == nil {
+		err = t.parseregfile(fn)
+	}
+	if err == nil && o.strict {
+		err = t.Validate()
+	}
+	if err != nil {
+		t.Close()
+	}
 	return t, err
 }
 
+// Close stops the target. Transactions not replied to yet, and any made
+// afterwards, get ErrTargetStopped. The connection is closed and every
+// goroutine of the target has exited when Close returns. Closing a closed
+// target does nothing.
+func (t Target) Close() error {
+	t.hw.closeonce.Do(func() {
+		close(t.stop)
+		<-t.stopped
+		close(t.hw.Stop)
+		<-t.hw.done
+	})
+	return t.hw.closeerr
+}
+
 func (t Target) String() string {
 	msg := fmt.Sprintf("Target at %v:\n", t.hw.raddr)
 	regnames := []string{}
@@ -78,33 +206,93 @@ func (t Target) String() string {
 // a) A full UDP packet worth of transactions can be sent
 // b) Target.dt has elapsed since the first queued transaction
 // or
-// c) Target.Dispatch() is called.
-// If disabled transactions are only sent when Target.Dispatch() is called.
+// c) Target.Dispatch() or Target.Flush() is called.
+// If disabled transactions are only sent when Target.Dispatch() or
+// Target.Flush() is called.
 func (t *Target) AllowAutoDispatch(enable bool) {
 
 }
 */
 
 func (t Target) preparepackets() {
+	defer close(t.stopped)
 	packs := make([]*packet, 0, 8)
+	// Pass packets on to the hw to be sent.
+	send := func(ps []*packet) {
+		for _, p := range ps {
+			select {
+			case t.hw.incoming <- p:
+			case <-t.stop:
+				p.fail(ErrTargetStopped)
+			}
+		}
+	}
+	// With AutoDispatch partial packets are sent after a delay.
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	stoptimer := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+	}
+	// Add parts of a request to packets. If a part cannot be added the
+	// request ends with the error, reported with the reply to the part
+	// before it if there is one.
+	var last *packet // Packet holding the part of the request added last
+	add := func(p *packet, tr transaction) bool {
+		err := p.add(tr)
+		if err == nil {
+			last = p
+			return true
+		}
+		t.hw.report(err)
+		if last == nil {
+			tr.closechan = true
+			tr.deliver(Response{err, 0xe, nil, nil})
+			return false
+		}
+		prev := &last.transactions[len(last.transactions)-1]
+		prev.closechan = true
+		prev.err = err
+		return false
+	}
 	running := true
 	for running {
 		select {
+		case <-timeout:
+			if verbose {
+				fmt.Printf("Dispatch timeout. %d packets ready.\n", len(packs))
+			}
+			timer, timeout = nil, nil
+			send(packs)
+			packs = []*packet{}
 		case req := <-t.requests:
 			if req.dispatch {
 				// Dispatch any queued full or partial packets
 				if verbose {
 					fmt.Printf("Dispatch request. %d packets ready.\n", len(packs))
 				}
-				for _, p := range packs {
-					t.hw.incoming <- p
-					//t.send(p)
-				}
+				stoptimer()
+				send(packs)
 				packs = []*packet{}
+				if req.flushed != nil || req.ctx != nil {
+					// The hw applies the dispatch to every packet it has,
+					// including those sent automatically.
+					marker := &packet{dispatchctx: req.ctx}
+					if req.flushed != nil {
+						marker.done = []chan struct{}{req.flushed}
+					}
+					send([]*packet{marker})
+				}
+				if req.queued != nil {
+					close(req.queued)
+				}
 			} else {
 				// Add a new request to an existing or new packet
+				last = nil
 				if len(packs) == 0 {
-					packs = append(packs, emptypacket(control))
+					packs = append(packs, emptypacket(control, t.packetsize))
 				}
 				p := packs[len(packs)-1]
 				// Determine if the current pack has enough space to fit the next request.
@@ -117,7 +305,7 @@ func (t Target) preparepackets() {
 					for nwords > 0 {
 						reqspace, respspace := p.space()
 						if reqspace < 2 || respspace < 2 {
-							packs = append(packs, emptypacket(control))
+							packs = append(packs, emptypacket(control, t.packetsize))
 							p = packs[len(packs)-1]
 							reqspace, respspace = p.space()
 						}
@@ -131,12 +319,14 @@ func (t Target) preparepackets() {
 						nwords -= ntoread
 						// add read request with ntoread words
 						final := nwords == 0
-						t := newrequesttransaction(req.typeid, uint8(ntoread), req.addr, req.Input, req.resp, req.byteslice, final)
+						tr := newrequesttransaction(req.typeid, uint8(ntoread), req.addr, req.Input, req.resp, req.byteslice, final, req.ctx, req.reg)
 						if req.typeid == read {
 							req.addr += uint32(ntoread)
 
 						}
-						p.add(t)
+						if !add(p, tr) {
+							break
+						}
 					}
 				case req.typeid == write || req.typeid == writenoninc:
 					nwords := uint(len(req.Input))
@@ -144,7 +334,7 @@ func (t Target) preparepackets() {
 					for nwords > 0 {
 						reqspace, respspace = p.space()
 						if reqspace < 3 || respspace < 1 {
-							packs = append(packs, emptypacket(control))
+							packs = append(packs, emptypacket(control, t.packetsize))
 							p = packs[len(packs)-1]
 							reqspace, respspace = p.space()
 						}
@@ -158,12 +348,12 @@ func (t Target) preparepackets() {
 						nwords -= ntowrite
 						final := nwords == 0
 						// add write request with ntowrite words
-						t := newrequesttransaction(req.typeid, uint8(ntowrite),
+						tr := newrequesttransaction(req.typeid, uint8(ntowrite),
 							req.addr,
 							req.Input[index:index+ntowrite],
-							req.resp, req.byteslice, final)
-						if err := p.add(t); err != nil {
-							panic(err)
+							req.resp, req.byteslice, final, req.ctx, req.reg)
+						if !add(p, tr) {
+							break
 						}
 						if req.typeid == write {
 							req.addr += uint32(ntowrite)
@@ -173,22 +363,39 @@ func (t Target) preparepackets() {
 					}
 				case req.typeid == rmwbits:
 					if reqspace < 4 || respspace < 2 {
-						packs = append(packs, emptypacket(control))
+						packs = append(packs, emptypacket(control, t.packetsize))
 						p = packs[len(packs)-1]
 					}
 					// add request
-					t := newrequesttransaction(rmwbits, 1, req.addr, req.Input,
-						req.resp, req.byteslice, true)
-					p.add(t)
+					tr := newrequesttransaction(rmwbits, 1, req.addr, req.Input,
+						req.resp, req.byteslice, true, req.ctx, req.reg)
+					add(p, tr)
 				case req.typeid == rmwsum:
 					if reqspace < 3 || respspace < 2 {
-						packs = append(packs, emptypacket(control))
+						packs = append(packs, emptypacket(control, t.packetsize))
 						p = packs[len(packs)-1]
 					}
 					// add request
-					t := newrequesttransaction(rmwsum, 1, req.addr, req.Input,
-						req.resp, req.byteslice, true)
-					p.add(t)
+					tr := newrequesttransaction(rmwsum, 1, req.addr, req.Input,
+						req.resp, req.byteslice, true, req.ctx, req.reg)
+					add(p, tr)
+				}
+				if req.autodispatch {
+					// Send every full packet straight away, the last one
+					// once the period has passed since the first
+					// transaction was queued.
+					nfull := len(packs) - 1
+					if packs[nfull].full() {
+						nfull++
+					}
+					send(packs[:nfull])
+					packs = packs[nfull:]
+					if len(packs) == 0 {
+						stoptimer()
+					} else if timer == nil {
+						timer = time.NewTimer(req.period)
+						timeout = timer.C
+					}
 				}
 			}
 		case _, ok := <-t.stop:
@@ -198,15 +405,38 @@ func (t Target) preparepackets() {
 			}
 		}
 	}
+	stoptimer()
+	for _, p := range packs {
+		p.fail(ErrTargetStopped)
+	}
 }
 
-// Blocking call to send queued transactions, returns once all replies are received.
+// Blocking call to send queued transactions, returns once all replies are
+// received and sent to their response channels, which have room for them.
 func (t Target) Dispatch() {
-	// Make sure any partial packets are in the outgoing queue
-	r := usrrequest{dispatch: true}
-	t.enqueue(r)
-	// Send queued packets
+	t.DispatchCtx(context.Background())
+}
+
+// Send queued transactions without waiting for their replies.
+func (t Target) Flush() {
+	queued := make(chan struct{})
+	t.enqueue(usrrequest{dispatch: true, queued: queued})
+	<-queued
+}
+
+// Errors of the target that are not the error of a single transaction, e.g.
+// replies that match no request, and errors that failed transactions, e.g.
+// a lost connection. Errors are dropped if the channel is full.
+func (t Target) Errors() <-chan error {
+	return t.hw.errs
+}
 
+// Change how lost packets are recovered, see RetryPolicy.
+func (t Target) SetRetryPolicy(p RetryPolicy) {
+	select {
+	case t.hw.policies <- p:
+	case <-t.hw.done:
+	}
 }
 
 func (t *Target) send(p *packet) {
@@ -214,120 +444,193 @@ func (t *Target) send(p *packet) {
 }
 
 func (t *Target) enqueue(r usrrequest) {
-	t.requests <- r
+	select {
+	case t.requests <- r:
+	case <-t.stop:
+		if r.resp != nil {
+			r.resp <- Response{ErrTargetStopped, 0xe, nil, nil}
+			close(r.resp)
+		}
+		for _, c := range []chan struct{}{r.queued, r.flushed} {
+			if c != nil {
+				close(c)
+			}
+		}
+	}
 }
 
-// Read nword words from register reg.
-func (t Target) Read(reg Register, nword uint) chan Response {
-	resp := make(chan Response)
-	tid := read
-	if reg.noninc {
-		tid = readnoninc
-	}
-	r := usrrequest{tid, nword, reg.Addr, []uint32{}, resp, false, false}
-	t.enqueue(r)
+// Reply to a request that is rejected before being sent.
+func rejected(err error, code InfoCode) chan Response {
+	resp := make(chan Response, 1)
+	resp <- Response{err, code, nil, nil}
+	close(resp)
 	return resp
 }
 
-// Write words in data to register reg.
-func (t Target) Write(reg Register, data []uint32) chan Response {
-	resp := make(chan Response)
-	tid := write
-	if reg.noninc {
-		tid = writenoninc
+// Check that reg can be read, or written if write is true.
+func checkpermission(reg Register, write bool) (chan Response, bool) {
+	if write && !reg.Permission.Writable() {
+		return rejected(&PermissionError{reg, "write"}, BusWriteError), false
 	}
-	r := usrrequest{tid, uint(len(data)), reg.Addr, data, resp, false, false}
-	t.enqueue(r)
-	return resp
+	if !write && !reg.Permission.Readable() {
+		return rejected(&PermissionError{reg, "read"}, BusReadError), false
+	}
+	return nil, true
+}
+
+// Check that a transfer of nword words fits inside reg, returning the number
+// of words to transfer. Non-incrementing registers and registers of unknown
+// size are not checked.
+func (t Target) checksize(reg Register, nword uint) (uint, error) {
+	if reg.noninc || reg.size <= 0 || nword <= uint(reg.size) {
+		return nword, nil
+	}
+	if t.ClampTransfers {
+		return uint(reg.size), nil
+	}
+	return nword, &SizeError{reg, nword}
+}
+
+// Read nword words from register reg. Reading a write-only register
+// replies with a *PermissionError and reading past the end of the register
+// with a *SizeError, without sending anything.
+func (t Target) Read(reg Register, nword uint) chan Response {
+	return t.read(nil, reg, nword, false)
+}
+
+// Write words in data to register reg. Writing a read-only register
+// replies with a *PermissionError and writing past the end of the register
+// with a *SizeError, without sending anything.
+func (t Target) Write(reg Register, data []uint32) chan Response {
+	return t.write(nil, reg, data)
 }
 
 // Update reg by operation: x = (x & andterm) | orterm. Receive previous value of reg in reply.
 func (t Target) RMWbits(reg Register, andterm, orterm uint32) chan Response {
-	resp := make(chan Response)
-	data := []uint32{andterm, orterm}
-	r := usrrequest{rmwbits, uint(1), reg.Addr, data, resp, false, false}
-	t.enqueue(r)
-	return resp
+	return t.rmw(nil, reg, rmwbits, []uint32{andterm, orterm})
 }
 
 // Update reg by operation: x <= (x + addend). Receive previous value of reg in reply.
 func (t Target) RMWsum(reg Register, addend uint32) chan Response {
-	resp := make(chan Response)
-	data := []uint32{addend}
-	r := usrrequest{rmwsum, uint(1), reg.Addr, data, resp, false, false}
-	t.enqueue(r)
-	return resp
+	return t.rmw(nil, reg, rmwsum, []uint32{addend})
 }
 
 // Read transaction where reply is kept in []byte array.
 func (t Target) ReadB(reg Register, nword uint) chan Response {
-	resp := make(chan Response)
+	return t.read(nil, reg, nword, true)
+}
+
+// Most transactions a request for nword words can be split into. Each
+// transaction but the last has 255 words or fills its packet.
+func (t Target) ntransactions(tid typeID, nword uint) int {
+	size := uint(t.packetsize / 4)
+	perpacket := size - 2 // Packet header and reply header
+	if tid == write || tid == writenoninc {
+		perpacket = size - 3 // Packet header, request header and address
+	}
+	switch tid {
+	case read, readnoninc, write, writenoninc:
+		return 2 + int(nword/255) + int((nword+perpacket-1)/perpacket)
+	}
+	return 1
+}
+
+// Queue a read of reg. Transactions queued with a context are abandoned
+// once it is done, see DispatchCtx.
+func (t Target) read(ctx context.Context, reg Register, nword uint, byteslice bool) chan Response {
+	if resp, ok := checkpermission(reg, false); !ok {
+		return resp
+	}
+	nword, err := t.checksize(reg, nword)
+	if err != nil {
+		return rejected(err, BusReadError)
+	}
 	tid := read
 	if reg.noninc {
 		tid = readnoninc
 	}
-	r := usrrequest{tid, nword, reg.Addr, []uint32{}, resp, true, false}
-	t.enqueue(r)
+	return t.request(ctx, tid, nword, reg.Name, reg.Addr, []uint32{}, byteslice)
+}
+
+func (t Target) write(ctx context.Context, reg Register, data []uint32) chan Response {
+	if resp, ok := checkpermission(reg, true); !ok {
+		return resp
+	}
+	nword, err := t.checksize(reg, uint(len(data)))
+	if err != nil {
+		return rejected(err, BusWriteError)
+	}
+	data = data[:nword]
+	tid := write
+	if reg.noninc {
+		tid = writenoninc
+	}
+	return t.request(ctx, tid, uint(len(data)), reg.Name, reg.Addr, data, false)
+}
+
+func (t Target) rmw(ctx context.Context, reg Register, tid typeID, data []uint32) chan Response {
+	if resp, ok := checkpermission(reg, true); !ok {
+		return resp
+	}
+	return t.request(ctx, tid, uint(1), reg.Name, reg.Addr, data, false)
+}
+
+func (t Target) request(ctx context.Context, tid typeID, nword uint, name string, addr uint32, data []uint32, byteslice bool) chan Response {
+	if ctx != nil && ctx.Err() != nil {
+		return rejected(ctx.Err(), 0xe)
+	}
+	if nword == 0 {
+		resp := make(chan Response)
+		close(resp)
+		return resp
+	}
+	// Room for every reply, so the hw never waits for the caller to read
+	// them.
+	resp := make(chan Response, t.ntransactions(tid, nword))
+	t.enqueue(usrrequest{typeid: tid, nwords: nword, addr: addr, Input: data, reg: name,
+		resp: resp, byteslice: byteslice, ctx: ctx,
+		autodispatch: t.AutoDispatch, period: t.TimeoutPeriod})
 	return resp
 }
 
 // MaskedWrite performs a RMWbits for updarting the masked part of the register to the given value
 func (t Target) MaskedWrite(reg Register, mask string, value uint32) (chan Response, error) {
-	m, ok := reg.msks[mask]
-	if !ok {
-		return make(chan Response), fmt.Errorf("MaskedWrite(): reg %v has no mask %s", reg, mask)
+	andterm, orterm, err := maskterms(reg, mask, value)
+	if err != nil {
+		return make(chan Response), err
 	}
-	andterm := 0xffffffff ^ m.value
-	orterm := value << m.shift
 	resp := t.RMWbits(reg, andterm, orterm)
 	return resp, nil
 }
 
+// Terms of an RMWbits setting mask of reg to value.
+func maskterms(reg Register, mask string, value uint32) (uint32, uint32, error) {
+	m, ok := reg.msks[mask]
+	if !ok {
+		return uint32(0), uint32(0), fmt.Errorf("MaskedWrite(): reg %v has no mask %s", reg, mask)
+	}
+	if !reg.Permission.Writable() {
+		return uint32(0), uint32(0), &PermissionError{reg, "write"}
+	}
+	return 0xffffffff ^ m.value, value << m.shift, nil
+}
+
 // Immediately send a write command and return any error once all return packets are received
 func (t Target) WriteNow(reg Register, data []uint32) error {
-	rc := t.Write(reg, data)
-	t.Dispatch()
-	for r := range rc {
-		if r.Err != nil {
-			return r.Err
-		}
-	}
-	return nil
+	return t.WriteCtx(context.Background(), reg, data)
 }
 
 // Immediately send read command and return all read words once all return packets are recieved
 func (t Target) ReadNow(reg Register, nword uint) ([]uint32, error) {
-	rc := t.Read(reg, nword)
-	t.Dispatch()
-	data := make([]uint32, 0, int(nword))
-	for r := range rc {
-		data = append(data, r.Data...)
-		if r.Err != nil {
-			return data, r.Err
-		}
-	}
-	return data, nil
+	return t.ReadCtx(context.Background(), reg, nword)
 }
 
 // Immediately perform a masked write on a register and return the previous value once return packet is received
 func (t Target) MaskedWriteNow(reg Register, mask string, value uint32) (uint32, error) {
-	rc, err := t.MaskedWrite(reg, mask, value)
-	if err != nil {
-		return uint32(0), err
-	}
-	t.Dispatch()
-	r := <-rc
-	if r.Err != nil {
-		return uint32(0), r.Err
-	}
-	return r.Data[0], nil
+	return t.MaskedWriteCtx(context.Background(), reg, mask, value)
 }
 
 // Immediately perform a read on a register and return the masked value once return packet is received
 func (t Target) MaskedReadNow(reg Register, mask string) (uint32, error) {
-	data, err := t.ReadNow(reg, 1)
-	if err != nil {
-		return uint32(0), err
-	}
-	return reg.ReadMask(mask, data[0])
+	return t.MaskedReadCtx(context.Background(), reg, mask)
 }
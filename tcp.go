@@ -0,0 +1,118 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+/*
+   Over TCP the IPbus packets are sent as a byte stream, so each packet is
+   preceded by a 32-bit big endian word giving the number of bytes that
+   follow. This is the framing used by uHAL for ipbustcp-2.0 URIs.
+   TCP is reliable and ordered, so the status/resend machinery used to
+   recover lost UDP packets is not needed.
+*/
+
+// Create a reliable Transport sending length prefixed IPbus packets on the
+// TCP connection conn.
+func NewTCPTransport(conn net.Conn) Transport {
+	return &tcptransport{conn: conn}
+}
+
+type tcptransport struct {
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+func (t *tcptransport) Send(packet []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, err := writeframe(t.conn, packet)
+	if err != nil {
+		return fmt.Errorf("Failed after sending %d bytes: %v", n, err)
+	}
+	return nil
+}
+
+func (t *tcptransport) Receive(buf []byte) (int, error) {
+	frame, err := readframe(t.conn, buf)
+	if err != nil {
+		return 0, err
+	}
+	if len(frame) > len(buf) {
+		return 0, &PacketError{fmt.Errorf("%d byte packet exceeds %d byte buffer", len(frame), len(buf))}
+	}
+	return len(frame), nil
+}
+
+func (t *tcptransport) Redial() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	raddr := t.conn.RemoteAddr()
+	conn, err := net.Dial(raddr.Network(), raddr.String())
+	if err != nil {
+		return err
+	}
+	t.conn.Close()
+	t.conn = conn
+	return nil
+}
+
+func (t *tcptransport) Reliable() bool {
+	return true
+}
+
+func (t *tcptransport) MaxPacketSize() int {
+	return defaultpacketsize()
+}
+
+func (t *tcptransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+func (t *tcptransport) Close() error {
+	return t.conn.Close()
+}
+
+// Write data preceded by its length in bytes.
+func writeframe(w io.Writer, data []byte) (int, error) {
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	n, err := w.Write(frame)
+	n -= 4
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+// Read a single length prefixed frame into buf, which is grown if needed.
+func readframe(r io.Reader, buf []byte) ([]byte, error) {
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(prefix)
+	if n > maxframesize {
+		return nil, fmt.Errorf("TCP frame of %d bytes exceeds maximum of %d bytes.", n, maxframesize)
+	}
+	if int(n) > cap(buf) {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Largest TCP frame accepted, to protect against a corrupt length prefix.
+const maxframesize = 1 << 20
@@ -6,6 +6,7 @@ package ipbus
 
 import (
 	//	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"time"
@@ -27,14 +28,33 @@ type transaction struct {
 	Input                []uint32
 	resp                 chan Response
 	byteslice, closechan bool
+	ctx                  context.Context // Abandon the transaction once done, if set
+	delivered            bool            // Reply already sent, e.g. an error once abandoned
+	reg                  string          // Name of the register, for errors
+	err                  error           // Reported with the reply, e.g. when the rest of the request failed
 }
 
-func newrequesttransaction(tid typeID, words uint8, addr uint32, input []uint32, resp chan Response, byteslice, final bool) transaction {
+func newrequesttransaction(tid typeID, words uint8, addr uint32, input []uint32, resp chan Response, byteslice, final bool, ctx context.Context, reg string) transaction {
 	header := transactionheader{uint8(protocolversion), 0x0, words, tid, Request}
-	trans := transaction{header, addr, input, resp, byteslice, final}
+	trans := transaction{header, addr, input, resp, byteslice, final, ctx, false, reg, nil}
 	return trans
 }
 
+// Send the reply of the transaction, closing the channel after the last.
+func (t *transaction) deliver(r Response) {
+	if t.delivered {
+		return
+	}
+	t.delivered = true
+	if r.Err == nil && t.err != nil {
+		r.Err = t.err
+	}
+	t.resp <- r
+	if t.closechan {
+		close(t.resp)
+	}
+}
+
 type packet struct {
 	header          packetheader
 	id              uint16
@@ -45,6 +65,11 @@ type packet struct {
 	//	request         *bytes.Buffer
 	request []byte
 	sent    time.Time
+	done    []chan struct{} // Closed once the replies have been sent
+	// A packet without transactions marks a dispatch: its done channels
+	// are closed once every earlier packet has been replied to, and
+	// transactions without a context are given dispatchctx.
+	dispatchctx context.Context
 }
 
 func (p packet) String() string {
@@ -60,86 +85,99 @@ func (p packet) Bytes() []byte {
 	return p.request
 }
 
-// Parse an IPbus reply byte stream into responses
+// Parse an IPbus reply byte stream into responses. Transactions without a
+// valid reply get an error response.
 func (p *packet) parse(data []byte) error {
 	packheader, err := newPacketHeader(data)
 	if err != nil {
-		for _ = range p.transactions {
-			resp := Response{err, 0xe, nil, nil}
-			p.replies = append(p.replies, resp)
-
-		}
+		p.failreplies(err)
 		return err
 	}
 	if packheader.ptype == control {
 		data = data[4:]
 		for len(data) > 0 {
 			transheader, err := newTransactionHeader(data, packheader.order)
+			if err != nil {
+				p.failreplies(err)
+				return err
+			}
 			tid := int(transheader.id)
 			if tid >= len(p.transactions) {
-				fmt.Printf("Found wrong transaction ID = %d. Only %d transactions.", tid, len(p.transactions))
-				fmt.Printf("p = %v\n", p)
-				panic(fmt.Errorf("Invalid transaction ID."))
+				err := fmt.Errorf("Reply to packet %d has transaction ID = %d, only %d transactions.", p.id, tid, len(p.transactions))
+				p.failreplies(err)
+				return err
 			}
 			trans := p.transactions[transheader.id]
 			resp := Response{err, transheader.code, nil, nil}
-			if err == nil { // heard successfully parsed
-				if transheader.code == Success {
-					data = data[4:]
-					switch {
-					case transheader.tid == read || transheader.tid == readnoninc:
-						if trans.byteslice {
-							resp.DataB = data[:int(transheader.words)*4]
-						} else {
-							resp.Data = bytes2uint32s(data[:int(transheader.words)*4], packheader.order)
-						}
-						if verbose {
-							fmt.Printf("Skipping %d words, %d bytes.\n", transheader.words, 4*int(transheader.words))
-						}
-						data = data[int(transheader.words)*4:]
-					case transheader.tid == write || transheader.tid == writenoninc:
-						if trans.byteslice {
-							resp.DataB = []byte{}
-						} else {
-							resp.Data = []uint32{}
-						}
-					case transheader.tid == rmwbits || transheader.tid == rmwsum:
-						if trans.byteslice {
-							resp.DataB = data[:4]
-						} else {
-							resp.Data = bytes2uint32s(data[:4], packheader.order)
-						}
-						data = data[4:]
+			if transheader.code == Success {
+				data = data[4:]
+				nbytes := 0
+				switch {
+				case transheader.tid == read || transheader.tid == readnoninc:
+					nbytes = int(transheader.words) * 4
+				case transheader.tid == rmwbits || transheader.tid == rmwsum:
+					nbytes = 4
+				}
+				if len(data) < nbytes {
+					err := fmt.Errorf("Reply to packet %d is truncated in transaction %d.", p.id, tid)
+					p.failreplies(err)
+					return err
+				}
+				switch {
+				case transheader.tid == read || transheader.tid == readnoninc:
+					if trans.byteslice {
+						resp.DataB = data[:nbytes]
+					} else {
+						resp.Data = bytes2uint32s(data[:nbytes], packheader.order)
+					}
+					if verbose {
+						fmt.Printf("Skipping %d words, %d bytes.\n", transheader.words, nbytes)
+					}
+				case transheader.tid == write || transheader.tid == writenoninc:
+					if trans.byteslice {
+						resp.DataB = []byte{}
+					} else {
+						resp.Data = []uint32{}
 					}
-				} else { // info code is not success
+				case transheader.tid == rmwbits || transheader.tid == rmwsum:
+					if trans.byteslice {
+						resp.DataB = data[:nbytes]
+					} else {
+						resp.Data = bytes2uint32s(data[:nbytes], packheader.order)
+					}
+				}
+				data = data[nbytes:]
+			} else { // info code is not success
+				if verbose {
 					fmt.Printf("Received a transaction info code: %v\n%v\n", transheader.code, p)
 					fmt.Printf("Transaction response = 0x%x\n", data)
-					resp.Err = fmt.Errorf("IPbus error: %s", transactionerrs[transheader.code])
-					// What do I do if there was an error, stop parsing now?
-					// Does it continue with replies to following transactions?
-					// Need to check IPbus docs.
-					data = data[4:]
 				}
+				resp.Err = &TransactionError{transheader.code, trans.reg, trans.Addr, p.id}
+				// The device does not carry out the rest of the packet, so
+				// the following transactions get no reply.
+				data = data[4:]
 			}
 			p.replies = append(p.replies, resp)
 		}
 		if len(p.replies) < len(p.transactions) {
-			err := fmt.Errorf("Did not receive sufficient bytes.")
-			for i := len(p.replies); i < len(p.transactions); i++ {
-				resp := Response{err, 0xe, nil, nil}
-				p.replies = append(p.replies, resp)
-			}
+			p.failreplies(fmt.Errorf("Did not receive sufficient bytes."))
 		}
 		return nil
-	} else if packheader.ptype == status {
-		// Need to do something special to parse status packet
-
-	} else if packheader.ptype == resend {
-		return fmt.Errorf("IPbus client shouldn't receive a resend request type packet.")
+	}
+	if packheader.ptype == resend {
+		err = fmt.Errorf("IPbus client shouldn't receive a resend request type packet.")
 	} else {
-		return fmt.Errorf("Packet has invalid type: 0x%x", packheader.ptype)
+		err = fmt.Errorf("Packet has invalid type: 0x%x", packheader.ptype)
+	}
+	p.failreplies(err)
+	return err
+}
+
+// Reply with err to the transactions that have no reply.
+func (p *packet) failreplies(err error) {
+	for i := len(p.replies); i < len(p.transactions); i++ {
+		p.replies = append(p.replies, Response{err, 0xe, nil, nil})
 	}
-	return nil
 }
 
 func byteorder(header []byte) (binary.ByteOrder, error) {
@@ -158,20 +196,46 @@ func byteorder(header []byte) (binary.ByteOrder, error) {
 }
 
 // Send replies back over correct channels.
-func (p packet) send() {
-	for i, tr := range p.transactions {
-		tr.resp <- p.replies[i]
-		if tr.closechan {
-			close(tr.resp)
+func (p *packet) send() {
+	for i := range p.transactions {
+		p.transactions[i].deliver(p.replies[i])
+	}
+	for _, done := range p.done {
+		close(done)
+	}
+	p.done = nil
+}
+
+// Reply with err to the transactions of p not answered yet and close its
+// done channels, when p cannot be sent or its reply will never come.
+func (p *packet) fail(err error) {
+	for i := range p.transactions {
+		p.transactions[i].deliver(Response{err, 0xe, nil, nil})
+	}
+	for _, done := range p.done {
+		close(done)
+	}
+	p.done = nil
+}
+
+// Reply with ctx.Err() to the transactions of p queued with ctx that are not
+// answered yet. The packet itself is still sent, or waited for, as the
+// device expects consecutive packet IDs.
+func (p *packet) abandon(ctx context.Context) {
+	for i := range p.transactions {
+		tr := &p.transactions[i]
+		if tr.ctx != nil && tr.ctx.Done() == ctx.Done() {
+			tr.deliver(Response{ctx.Err(), 0xe, nil, nil})
 		}
 	}
 }
 
-func emptypacket(pt packetType) *packet {
+// Create a packet with room for nbytes bytes of IPbus data.
+func emptypacket(pt packetType, nbytes int) *packet {
 	trans := make([]transaction, 0, 8)
 	replies := make([]Response, 0, 8)
 	//request := bytes.NewBuffer(make([]byte, 0, 1472))
-	request := make([]byte, 4, 1472)
+	request := make([]byte, 4, nbytes)
 	/*
 		header := uint32(0)
 		header |= protocolversion << 24
@@ -181,10 +245,10 @@ func emptypacket(pt packetType) *packet {
 	*/
 	// Normal IP packet has up to 1500 bytes. IP header is 20 bytes, UDP
 	// header is 8 bytes. This leaves 368 words for the ipbus data.
-	size := (MaxPacketSize - 28) / 4
+	size := uint(nbytes) / 4
 	header := packetheader{uint8(protocolversion), uint16(0),
 		pt, defaultorder}
-	return &packet{header, 0, trans, replies, size, size, 1, 1, request, time.Time{}} // For normal packet
+	return &packet{header, 0, trans, replies, size, size, 1, 1, request, time.Time{}, nil, nil} // For normal packet
 }
 
 func (p *packet) add(trans transaction) error {
@@ -269,6 +333,12 @@ func (p packet) space() (uint, uint) {
 	return p.reqcap - p.reqlen, p.respcap - p.resplen
 }
 
+// A full packet has no room for even a single word read.
+func (p packet) full() bool {
+	reqspace, respspace := p.space()
+	return reqspace < 2 || respspace < 2
+}
+
 func (p *packet) writeheader(id uint16) error {
 	p.header.pid = id
 	p.id = id
@@ -281,7 +351,15 @@ type usrrequest struct {
 	nwords    uint
 	addr      uint32
 	Input     []uint32
+	reg       string
 	resp      chan Response
 	byteslice bool
 	dispatch  bool
+	ctx       context.Context
+	// AutoDispatch and TimeoutPeriod of the target the request was made with.
+	autodispatch bool
+	period       time.Duration
+	// For dispatch requests, queued is closed once the packets are passed
+	// to the hw and flushed once all their replies have been sent.
+	queued, flushed chan struct{}
 }
@@ -0,0 +1,207 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// Transport carries IPbus packets between a Target and its device. The
+// package provides UDP, TCP, ControlHub and in-memory transports, others
+// (e.g. PCIe device files or a serial bridge) can be added by implementing
+// this interface and passing it to NewWithTransport.
+//
+// Send and Receive are called from different goroutines, but Send is never
+// called concurrently with itself, nor Receive with itself.
+type Transport interface {
+	// Send a single IPbus packet to the device.
+	Send(packet []byte) error
+	// Block until the next IPbus packet arrives, copy it into buf and
+	// return its length. If a request failed without breaking the
+	// transport return a *PacketError in place of its reply. Any other
+	// error stops the Target receiving.
+	Receive(buf []byte) (int, error)
+	// Reliable transports never lose or reorder packets, so the target
+	// neither asks the device for its status nor recovers lost packets.
+	Reliable() bool
+	// Largest IPbus packet, in bytes, that can be sent or received.
+	MaxPacketSize() int
+	// Address of the device.
+	RemoteAddr() net.Addr
+	// Close the transport, any blocked Receive should return an error.
+	Close() error
+}
+
+// A Transport that also implements Redialer is reconnected when Receive
+// reports that the connection was lost, e.g. because the device was power
+// cycled, instead of failing every later transaction.
+type Redialer interface {
+	// Replace the lost connection with a new one to the same device. Send
+	// and Receive are not called while Redial runs.
+	Redial() error
+}
+
+// PacketError is returned by Transport.Receive in place of the reply to the
+// oldest outstanding request when that request failed but the transport can
+// still be used, e.g. when a ControlHub gets no reply from the device.
+type PacketError struct {
+	Err error
+}
+
+func (e *PacketError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PacketError) Unwrap() error {
+	return e.Err
+}
+
+// Default size of an IPbus packet sent in a single UDP datagram: an Ethernet
+// frame minus the IP (20 byte) and UDP (8 byte) headers.
+func defaultpacketsize() int {
+	return int(MaxPacketSize) - 28
+}
+
+// Create a Transport for conn, using TCP framing if conn is a TCP connection
+// and UDP otherwise.
+func newconntransport(conn net.Conn) Transport {
+	if conn.RemoteAddr().Network() == "tcp" {
+		return NewTCPTransport(conn)
+	}
+	return NewUDPTransport(conn)
+}
+
+// Create a Transport sending one IPbus packet per UDP datagram on conn.
+// Lost packets are recovered using status and resend requests.
+func NewUDPTransport(conn net.Conn) Transport {
+	return &udptransport{conn}
+}
+
+type udptransport struct {
+	conn net.Conn
+}
+
+func (u *udptransport) Send(packet []byte) error {
+	n, err := u.conn.Write(packet)
+	if err != nil {
+		return fmt.Errorf("Failed after sending %d bytes: %v", n, err)
+	}
+	if n != len(packet) {
+		return fmt.Errorf("Sent %d of %d bytes.", n, len(packet))
+	}
+	return nil
+}
+
+func (u *udptransport) Receive(buf []byte) (int, error) {
+	for {
+		n, err := u.conn.Read(buf)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			// Nothing is listening at the device address (yet), so the
+			// request is lost.
+			continue
+		}
+		return n, err
+	}
+}
+
+func (u *udptransport) Redial() error {
+	raddr := u.conn.RemoteAddr()
+	conn, err := net.Dial(raddr.Network(), raddr.String())
+	if err != nil {
+		return err
+	}
+	u.conn.Close()
+	u.conn = conn
+	return nil
+}
+
+func (u *udptransport) Reliable() bool {
+	return false
+}
+
+func (u *udptransport) MaxPacketSize() int {
+	return defaultpacketsize()
+}
+
+func (u *udptransport) RemoteAddr() net.Addr {
+	return u.conn.RemoteAddr()
+}
+
+func (u *udptransport) Close() error {
+	return u.conn.Close()
+}
+
+// Create a reliable Transport that passes each packet to handle in process
+// and returns its result as the reply. If handle returns nil there is no
+// reply. This is useful to test code against a software model of a device.
+func NewMemoryTransport(handle func(request []byte) []byte) Transport {
+	return &memorytransport{handle: handle, replies: make(chan []byte, 64), done: make(chan bool)}
+}
+
+type memorytransport struct {
+	handle  func([]byte) []byte
+	replies chan []byte
+	done    chan bool
+	once    sync.Once
+}
+
+func (m *memorytransport) Send(packet []byte) error {
+	request := make([]byte, len(packet))
+	copy(request, packet)
+	reply := m.handle(request)
+	if reply == nil {
+		return nil
+	}
+	select {
+	case m.replies <- reply:
+		return nil
+	case <-m.done:
+		return fmt.Errorf("Memory transport is closed.")
+	}
+}
+
+func (m *memorytransport) Receive(buf []byte) (int, error) {
+	select {
+	case reply := <-m.replies:
+		if len(reply) > len(buf) {
+			return 0, &PacketError{fmt.Errorf("%d byte reply exceeds %d byte buffer", len(reply), len(buf))}
+		}
+		return copy(buf, reply), nil
+	case <-m.done:
+		return 0, io.EOF
+	}
+}
+
+func (m *memorytransport) Reliable() bool {
+	return true
+}
+
+func (m *memorytransport) MaxPacketSize() int {
+	return defaultpacketsize()
+}
+
+func (m *memorytransport) RemoteAddr() net.Addr {
+	return memoryaddr{}
+}
+
+func (m *memorytransport) Close() error {
+	m.once.Do(func() { close(m.done) })
+	return nil
+}
+
+type memoryaddr struct{}
+
+func (a memoryaddr) Network() string {
+	return "memory"
+}
+
+func (a memoryaddr) String() string {
+	return "memory"
+}
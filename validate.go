@@ -0,0 +1,185 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AddressTableError is a problem found in an address table.
+type AddressTableError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *AddressTableError) Error() string {
+	if e.Line == 0 {
+		// JSON address tables have no line numbers.
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// ValidationError lists every problem found in an address table.
+type ValidationError struct {
+	Problems []*AddressTableError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return fmt.Sprintf("Address table has %d problems:\n%s", len(e.Problems), strings.Join(msgs, "\n"))
+}
+
+func (n *Node) problem(format string, args ...interface{}) *AddressTableError {
+	return &AddressTableError{n.file, n.line, fmt.Sprintf(format, args...)}
+}
+
+// Check the address table file fn, and the modules it includes, for invalid
+// attributes, registers sharing an address, overlapping masks and nodes
+// extending outside the range of their parent. Returns a *ValidationError
+// listing every problem found, or the error that stopped the file from
+// being read.
+func ValidateAddressTable(fn string) error {
+	problems := []*AddressTableError{}
+	root, err := loadnode(fn, nil, uint32(0), &problems)
+	if err != nil {
+		return err
+	}
+	return validate(root, problems)
+}
+
+// Check the address table of the target, as ValidateAddressTable does.
+func (t Target) Validate() error {
+	if t.Root == nil {
+		return nil
+	}
+	return validate(t.Root, t.problems)
+}
+
+func validate(root *Node, problems []*AddressTableError) error {
+	problems = append(problems, checknodes(root)...)
+	problems = append(problems, checkoverlaps(root)...)
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{problems}
+}
+
+// Range of addresses [lo, hi) used by n and the nodes below it.
+func extent(n *Node) (uint64, uint64) {
+	lo, hi := uint64(n.Addr), uint64(n.Addr)
+	if n.isleaf() || n.Size > 0 {
+		hi = lo + uint64(n.span())
+	}
+	for _, c := range n.children {
+		if c.isfield() {
+			continue
+		}
+		clo, chi := extent(c)
+		if clo < lo {
+			lo = clo
+		}
+		if chi > hi {
+			hi = chi
+		}
+	}
+	return lo, hi
+}
+
+// Number of addresses taken by n itself.
+func (n *Node) span() int {
+	if isnoninc(n.Mode) || n.Size == 0 {
+		return 1
+	}
+	return n.Size
+}
+
+// A leaf has no nodes below it other than its bit fields.
+func (n *Node) isleaf() bool {
+	for _, c := range n.children {
+		if !c.isfield() {
+			return false
+		}
+	}
+	return true
+}
+
+// Check each node against its children: unique names, disjoint masks and
+// children inside the declared size of their parent.
+func checknodes(root *Node) []*AddressTableError {
+	problems := []*AddressTableError{}
+	root.Walk(func(n *Node) {
+		names := make(map[string]*Node)
+		fields := []*Node{}
+		for _, c := range n.children {
+			if prev, ok := names[c.Name]; ok && c.Name != "" {
+				problems = append(problems, c.problem("Duplicate node '%s', also declared at %s:%d.", c.Path(), prev.file, prev.line))
+			}
+			names[c.Name] = c
+			if c.isfield() {
+				for _, f := range fields {
+					if f.Mask&c.Mask != 0 {
+						problems = append(problems, c.problem("Mask 0x%x of '%s' overlaps mask 0x%x of '%s'.", c.Mask, c.Path(), f.Mask, f.Path()))
+					}
+				}
+				fields = append(fields, c)
+				continue
+			}
+			if n.Size > 0 && n.parent != nil {
+				lo, hi := extent(c)
+				if lo < uint64(n.Addr) || hi > uint64(n.Addr)+uint64(n.Size) {
+					problems = append(problems, c.problem("'%s' at 0x%x-0x%x is outside '%s' at 0x%x-0x%x.",
+						c.Path(), lo, hi-1, n.Path(), n.Addr, uint64(n.Addr)+uint64(n.Size)-1))
+				}
+			}
+		}
+	})
+	return problems
+}
+
+// Check that no two registers share an address, unless their masks are
+// disjoint.
+func checkoverlaps(root *Node) []*AddressTableError {
+	leaves := []*Node{}
+	root.Walk(func(n *Node) {
+		if n.parent != nil && !n.isfield() && n.isleaf() {
+			leaves = append(leaves, n)
+		}
+	})
+	sort.SliceStable(leaves, func(i, j int) bool {
+		return leaves[i].Addr < leaves[j].Addr
+	})
+	mask := func(n *Node) uint32 {
+		if n.Mask != 0 {
+			return n.Mask
+		}
+		return 0xffffffff
+	}
+	problems := []*AddressTableError{}
+	active := []*Node{}
+	for _, n := range leaves {
+		still := active[:0]
+		for _, a := range active {
+			if uint64(a.Addr)+uint64(a.span()) > uint64(n.Addr) {
+				still = append(still, a)
+			}
+		}
+		active = still
+		for _, a := range active {
+			if mask(a)&mask(n) != 0 {
+				problems = append(problems, n.problem("'%s' at 0x%x overlaps '%s' at 0x%x declared at %s:%d.",
+					n.Path(), n.Addr, a.Path(), a.Addr, a.file, a.line))
+			}
+		}
+		active = append(active, n)
+	}
+	return problems
+}
@@ -0,0 +1,193 @@
+// Copyright 2018 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipbus
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Add c below n, e.g. to build an address table to write out. The address
+// of c is global, like that of every node, and its own: c is a register or
+// module even if it has a mask. Use AddField for bit fields.
+func (n *Node) AddChild(c *Node) {
+	c.parent = n
+	c.hasaddr = true
+	n.children = append(n.children, c)
+}
+
+// Add the mask only node c below the register n as one of its bit fields,
+// at the address of n.
+func (n *Node) AddField(c *Node) {
+	c.parent = n
+	c.Addr = n.Addr
+	c.hasaddr = false
+	n.children = append(n.children, c)
+}
+
+// Write n and every node below it to w as a single uHAL address table, with
+// the contents of modules written in place of the module references.
+func (n *Node) WriteXML(w io.Writer) error {
+	return writexml(w, n, nil)
+}
+
+// Write n as the address table file fn, keeping the module layout it was
+// read with: each module is written to its own file, at the same path
+// relative to fn as the original module file was to the top file. A module
+// included more than once is written once.
+func (n *Node) WriteXMLFiles(fn string) error {
+	return n.writefile(fn, n.topdir(), make(map[string]string), writexml)
+}
+
+// Write a node and those below it, with module deciding which nodes refer
+// to a module file rather than holding their children.
+type tablewriter func(w io.Writer, n *Node, module func(*Node) (string, bool)) error
+
+// Directory of the file n was read from, relative to which module files
+// are placed.
+func (n *Node) topdir() string {
+	if n.Module != "" {
+		return filepath.Dir(n.Module)
+	}
+	return filepath.Dir(n.file)
+}
+
+// Path of module file modfn relative to srcdir, with the extension of the
+// format it is written in. Leading ".." elements are dropped, so that a
+// module from outside srcdir is written below the output directory rather
+// than next to, or over, the tables it was read from.
+func modulepath(srcdir, modfn, ext string) string {
+	rel, err := filepath.Rel(srcdir, modfn)
+	if err != nil {
+		rel = filepath.Base(modfn)
+	}
+	up := ".." + string(filepath.Separator)
+	for rel = filepath.Clean(rel); strings.HasPrefix(rel, up); {
+		rel = rel[len(up):]
+	}
+	return strings.TrimSuffix(rel, filepath.Ext(rel)) + ext
+}
+
+// Write n to fn, and the modules below it to files of their own. written
+// maps the module files already written to the modules they hold.
+func (n *Node) writefile(fn, srcdir string, written map[string]string, write tablewriter) error {
+	modules := []*Node{}
+	buf := &bytes.Buffer{}
+	err := write(buf, n, func(m *Node) (string, bool) {
+		if m == n || m.Module == "" {
+			return "", false
+		}
+		modules = append(modules, m)
+		return modulepath(srcdir, m.Module, filepath.Ext(fn)), true
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(fn, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	for _, m := range modules {
+		rel := modulepath(srcdir, m.Module, filepath.Ext(fn))
+		modfn := filepath.Join(filepath.Dir(fn), rel)
+		if src, ok := written[modfn]; ok {
+			if src != m.Module {
+				return fmt.Errorf("Modules %s and %s would both be written to %s.", src, m.Module, modfn)
+			}
+			continue
+		}
+		written[modfn] = m.Module
+		// The module file only holds the children, the including node
+		// keeps the attributes.
+		top := &Node{Module: m.Module, children: m.children, Addr: m.Addr, file: m.Module}
+		if err := top.writefile(modfn, filepath.Dir(m.Module), written, write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the XML for n and the nodes below it. If module returns true for a
+// node, only the node is written, with a reference to the module file
+// returned instead of its children.
+func writexml(w io.Writer, n *Node, module func(*Node) (string, bool)) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "    ")
+	if err := encodenode(enc, n, module); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func encodenode(enc *xml.Encoder, n *Node, module func(*Node) (string, bool)) error {
+	attrs := []xml.Attr{}
+	attr := func(name, value string) {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+	}
+	if n.Name != "" {
+		attr("id", n.Name)
+	}
+	if n.parent != nil && !n.isfield() {
+		attr("address", fmt.Sprintf("0x%08x", n.Addr-n.parent.Addr))
+	}
+	if n.Mask != 0 {
+		attr("mask", fmt.Sprintf("0x%08x", n.Mask))
+	}
+	modfn, ismodule := "", false
+	if module != nil {
+		modfn, ismodule = module(n)
+	}
+	if ismodule {
+		attr("module", "file://"+filepath.ToSlash(modfn))
+	}
+	if n.Permission != ReadWrite {
+		attr("permission", n.Permission.String())
+	}
+	if n.Mode != "" {
+		attr("mode", n.Mode)
+	}
+	if n.hassize || (n.Size > 0 && !strings.Contains(n.FWInfo, "width")) {
+		attr("size", fmt.Sprintf("%d", n.Size))
+	}
+	if n.Description != "" {
+		attr("description", n.Description)
+	}
+	if n.FWInfo != "" {
+		attr("fwinfo", n.FWInfo)
+	}
+	if len(n.Tags) > 0 {
+		attr("tags", strings.Join(n.Tags, ","))
+	}
+	if len(n.Parameters) > 0 {
+		attr("parameters", parameterstring(n.Parameters))
+	}
+	start := xml.StartElement{Name: xml.Name{Local: "node"}, Attr: attrs}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if !ismodule {
+		for _, c := range n.children {
+			if err := encodenode(enc, c, module); err != nil {
+				return err
+			}
+		}
+	}
+	return enc.EncodeToken(start.End())
+}